@@ -0,0 +1,174 @@
+package memo
+
+import (
+	"context"
+)
+
+// GetMany returns the associated values of the keys, values are returned
+// in the first map and errors are returned in the second map by key.
+// If some values are not found(or expired) but a bulk loader is provided,
+// they are got from the store if provided, and the bulk loader will be
+// invoked only once to get the rest of them, while the keys which are
+// being loaded by other callers are waited for. The keys absent from the
+// result of the bulk loader get ErrNotFound. If no bulk loader is
+// provided, it is the same as calling Get for each key.
+func (m *Memo[K, V]) GetMany(keys []K, opts ...GetOption[K, V]) (map[K]V, map[K]error) {
+	return m.GetManyContext(context.Background(), keys, opts...)
+}
+
+// GetManyContext is the same as GetMany, except that the caller can
+// give up waiting for the values when ctx is done, see GetContext.
+func (m *Memo[K, V]) GetManyContext(ctx context.Context, keys []K, opts ...GetOption[K, V]) (map[K]V, map[K]error) {
+	o := m.o.newGetOptions(opts...)
+	values, errs := make(map[K]V, len(keys)), make(map[K]error)
+
+	collect := func(k K, v V, err error) {
+		if err != nil {
+			errs[k] = err
+		} else {
+			values[k] = v
+		}
+	}
+
+	if o.bulkLoader == nil {
+		for _, k := range keys {
+			v, err := m.GetContext(ctx, k, opts...)
+			collect(k, v, err)
+		}
+
+		return values, errs
+	}
+
+	now := m.o.clock.Now()
+
+	// The entries to be loaded, and the loads waited for,
+	// including those of the entries to be loaded.
+	var loading []*entry[K, V]
+
+	waiting := make(map[K]*call[V])
+
+	seen := make(map[K]struct{}, len(keys))
+	for _, k := range keys {
+		if _, ok := seen[k]; ok {
+			continue
+		}
+
+		seen[k] = struct{}{}
+
+		s := m.shard(k)
+		s.mu.Lock()
+		s.cleanup(now)
+
+		e := s.lookup(k, now)
+		if e == nil {
+			s.counters.misses.Add(1)
+
+			e = newEntry[K, V](k)
+			e.call = newCall[V]()
+			e.refreshAt = m.refreshAt(now)
+			s.c.dictSet(k, e)
+			s.expire(e, now, o.expiration)
+			s.unlock()
+
+			loading = append(loading, e)
+			waiting[k] = e.call
+
+			continue
+		}
+
+		s.counters.hits.Add(1)
+		s.c.policyAccess(e)
+
+		if c := e.call; c != nil {
+			s.unlock()
+
+			waiting[k] = c
+
+			continue
+		}
+
+		m.slide(s, e, o, now)
+		refresh := m.refreshable(e, o, now)
+		v, err, version := e.value, e.err, e.version
+		s.unlock()
+
+		if refresh {
+			go m.refresh(context.WithoutCancel(ctx), s, e, o, version)
+		}
+
+		collect(k, v, err)
+	}
+
+	if len(loading) != 0 {
+		// The caller can not give up if ctx is never done, so
+		// there is no need to load in another goroutine.
+		if ctx.Done() == nil {
+			m.loadMany(ctx, loading, o)
+		} else {
+			go m.loadMany(ctx, loading, o)
+		}
+	}
+
+	for k, c := range waiting {
+		v, err := c.wait(ctx)
+		collect(k, v, err)
+	}
+
+	return values, errs
+}
+
+// loadMany invokes the bulk loader for the entries and settles the results.
+func (m *Memo[K, V]) loadMany(ctx context.Context, entries []*entry[K, V], o getOptions[K, V]) {
+	ctx, cancel := detach(ctx)
+	defer cancel()
+	defer func(entries []*entry[K, V]) {
+		for _, e := range entries {
+			m.abandon(m.shard(e.key), e, o)
+		}
+	}(entries)
+
+	// The entries found in the store are settled first.
+	pending := make([]*entry[K, V], 0, len(entries))
+	for _, e := range entries {
+		v, ttl, err := m.tier.get(ctx, e.key)
+		if err != nil {
+			pending = append(pending, e)
+
+			continue
+		}
+
+		o := o
+		o.expiration = within(o.expiration, ttl)
+		m.settle(m.shard(e.key), e, o, v, nil, false)
+	}
+
+	if len(pending) == 0 {
+		return
+	}
+
+	entries = pending
+
+	keys := make([]K, 0, len(entries))
+	for _, e := range entries {
+		keys = append(keys, e.key)
+	}
+
+	start := m.o.clock.Now()
+	values, err := retry(ctx, m.o.clock, m.o.retryPolicy, func() (map[K]V, error) {
+		return protect(func() (map[K]V, error) { return o.bulkLoader(ctx, keys) })
+	})
+	m.recordLoad(start, err)
+
+	for _, e := range entries {
+		v, ok := values[e.key]
+
+		switch {
+		case err != nil:
+			m.settle(m.shard(e.key), e, o, v, err, false)
+		case !ok:
+			m.settle(m.shard(e.key), e, o, v, ErrNotFound, false)
+		default:
+			m.settle(m.shard(e.key), e, o, v, nil, true)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package memo
+
+import (
+	"context"
+)
+
+// call is an in-flight or completed call, which is shared by the
+// callers of the same key, both in memo loads and in Group.
+type call[V any] struct {
+	// A channel which is closed when the call is done,
+	// the result is immutable after that.
+	done  chan struct{}
+	value V
+	err   error
+	// Whether the call is joined by other callers, it is
+	// guarded by the lock of the owner of the call.
+	shared bool
+}
+
+func newCall[V any]() *call[V] {
+	return &call[V]{done: make(chan struct{})}
+}
+
+// settle stores the result and wakes up the waiters.
+func (c *call[V]) settle(v V, err error) {
+	c.value, c.err = v, err
+	close(c.done)
+}
+
+// wait waits for the result of the call, or ctx to be done.
+func (c *call[V]) wait(ctx context.Context) (V, error) {
+	select {
+	case <-c.done:
+		return c.value, c.err
+	case <-ctx.Done():
+		var zero V
+
+		return zero, ctx.Err()
+	}
+}
+
+// settled reports whether the call is done.
+func (c *call[V]) settled() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,5 +1,11 @@
 package memo
 
+import (
+	"context"
+	"sync"
+	"time"
+)
+
 // A Clock represents the passage of time, it can provide the current time
 // in nanoseconds, which could be a relative value, not an absolute value.
 type Clock interface {
@@ -7,6 +13,15 @@ type Clock interface {
 	Now() int64
 }
 
+// A Sleeper is a Clock which can also wait for the passage of time, it is
+// optional, time-driven components fall back to real timers without it.
+type Sleeper interface {
+	Clock
+	// Sleep pauses until d elapsed in the clock, or returns
+	// ctx.Err() earlier if ctx is done.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
 // A RealClock can provide the real current time.
 type RealClock struct{}
 
@@ -19,3 +34,139 @@ func NewRealClock() RealClock {
 func (rc RealClock) Now() int64 {
 	return nanotime()
 }
+
+// Sleep pauses until d elapsed in real time or ctx is done.
+func (rc RealClock) Sleep(ctx context.Context, d time.Duration) error {
+	return sleep(ctx, d)
+}
+
+// A ManualClock is a clock whose time only changes when it is told to,
+// it is safe for concurrent use, and is designed for deterministic tests.
+type ManualClock struct {
+	mu   sync.Mutex
+	cond sync.Cond
+	now  int64
+	// The goroutines sleeping on the clock.
+	sleepers map[chan struct{}]int64
+}
+
+// NewManualClock creates a manual clock whose current time is 0.
+func NewManualClock() *ManualClock {
+	mc := &ManualClock{sleepers: make(map[chan struct{}]int64)}
+	mc.cond.L = &mc.mu
+
+	return mc
+}
+
+// Now returns the current time in nanoseconds.
+func (mc *ManualClock) Now() int64 {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	return mc.now
+}
+
+// Advance moves the current time forward by d, and wakes
+// up the goroutines whose sleeping time has elapsed.
+func (mc *ManualClock) Advance(d time.Duration) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.set(mc.now + int64(d))
+}
+
+// Set sets the current time to now in nanoseconds, and wakes
+// up the goroutines whose sleeping time has elapsed.
+func (mc *ManualClock) Set(now int64) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.set(now)
+}
+
+func (mc *ManualClock) set(now int64) {
+	mc.now = now
+
+	for ch, until := range mc.sleepers {
+		if until <= now {
+			close(ch)
+			delete(mc.sleepers, ch)
+		}
+	}
+
+	mc.cond.Broadcast()
+}
+
+// Sleep pauses until d elapsed in the clock or ctx is done.
+func (mc *ManualClock) Sleep(ctx context.Context, d time.Duration) error {
+	mc.mu.Lock()
+
+	if d <= 0 {
+		mc.mu.Unlock()
+
+		return ctx.Err()
+	}
+
+	ch := make(chan struct{})
+	mc.sleepers[ch] = mc.now + int64(d)
+	mc.cond.Broadcast()
+	mc.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		mc.mu.Lock()
+		delete(mc.sleepers, ch)
+		mc.cond.Broadcast()
+		mc.mu.Unlock()
+
+		return ctx.Err()
+	}
+}
+
+// Sleepers returns the number of goroutines sleeping on the clock.
+func (mc *ManualClock) Sleepers() int {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	return len(mc.sleepers)
+}
+
+// BlockUntil blocks until at least n goroutines are sleeping on the clock,
+// it is useful to make sure a background goroutine has reached a point
+// where it waits for the clock, before advancing the clock.
+func (mc *ManualClock) BlockUntil(n int) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	for len(mc.sleepers) < n {
+		mc.cond.Wait()
+	}
+}
+
+// sleepOn pauses on the clock if it is a Sleeper, or in real time.
+func sleepOn(ctx context.Context, clock Clock, d time.Duration) error {
+	if sleeper, ok := clock.(Sleeper); ok {
+		return sleeper.Sleep(ctx, d)
+	}
+
+	return sleep(ctx, d)
+}
+
+// sleep pauses until d elapsed in real time or ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
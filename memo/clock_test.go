@@ -1,6 +1,8 @@
 package memo_test
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -30,6 +32,65 @@ func TestClock(t *testing.T) {
 	}
 }
 
+func TestManualClock(t *testing.T) {
+	mc := memo.NewManualClock()
+	if now := mc.Now(); now != 0 {
+		t.Errorf("got: %v, want: %v", now, 0)
+	}
+
+	woken := make(chan time.Duration, 3)
+	for _, d := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		go func() {
+			if err := mc.Sleep(context.Background(), d); err == nil {
+				woken <- d
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		canceled <- mc.Sleep(ctx, time.Hour)
+	}()
+
+	mc.BlockUntil(4)
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Errorf("got: %v, want: %v", err, context.Canceled)
+	}
+
+	if n := mc.Sleepers(); n != 3 {
+		t.Errorf("got: %v, want: %v", n, 3)
+	}
+
+	mc.Advance(time.Second)
+	if d := <-woken; d != time.Second {
+		t.Errorf("got: %v, want: %v", d, time.Second)
+	}
+
+	mc.Set(int64(2 * time.Second))
+	if d := <-woken; d != 2*time.Second {
+		t.Errorf("got: %v, want: %v", d, 2*time.Second)
+	}
+
+	if now := mc.Now(); now != int64(2*time.Second) {
+		t.Errorf("got: %v, want: %v", now, int64(2*time.Second))
+	}
+
+	if n := mc.Sleepers(); n != 1 {
+		t.Errorf("got: %v, want: %v", n, 1)
+	}
+
+	mc.Advance(time.Second)
+	if d := <-woken; d != 3*time.Second {
+		t.Errorf("got: %v, want: %v", d, 3*time.Second)
+	}
+
+	if err := mc.Sleep(context.Background(), 0); err != nil {
+		t.Errorf("got: %v, want: %v", err, nil)
+	}
+}
+
 func BenchmarkClock(b *testing.B) {
 	b.Run("TimeClock", func(b *testing.B) {
 		b.RunParallel(func(pb *testing.PB) {
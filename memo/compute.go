@@ -0,0 +1,127 @@
+package memo
+
+// Compute atomically replaces the value of the key with the result
+// of fn, which receives the current value and whether it is present.
+// If fn returns false, the key is deleted instead. If the value is
+// being loaded, Compute waits for the load first. It returns the new
+// value and whether the key is present. The expiration option acts
+// on the new value. fn is called with the shard locked, so it must
+// not access the memo.
+func (m *Memo[K, V]) Compute(k K, fn func(old V, ok bool) (V, bool), opts ...SetOption[K, V]) (V, bool) {
+	o := m.o.newSetOptions(opts...)
+
+	s, e, now := m.acquire(k)
+	defer s.unlock()
+
+	old, ok := present(e)
+
+	v, keep := fn(old, ok)
+	if !keep {
+		if e != nil {
+			s.remove(e, Deleted)
+		}
+
+		s.deleteThrough(k)
+
+		var zero V
+
+		return zero, false
+	}
+
+	m.put(s, e, k, v, o, now)
+
+	return v, true
+}
+
+// GetOrSet returns the existing value of the key if it is present.
+// Otherwise, it sets the value and returns it. The loaded result is
+// true if the value is loaded, false if set. The loader is never
+// invoked and the store is not consulted, but a value being loaded
+// is waited for.
+func (m *Memo[K, V]) GetOrSet(k K, v V, opts ...SetOption[K, V]) (V, bool) {
+	o := m.o.newSetOptions(opts...)
+
+	s, e, now := m.acquire(k)
+	defer s.unlock()
+
+	if old, ok := present(e); ok {
+		s.c.policyAccess(e)
+
+		return old, true
+	}
+
+	m.put(s, e, k, v, o, now)
+
+	return v, false
+}
+
+// CompareAndSwap sets the value of the key to v if the current value
+// is equal to old, it reports whether the value is swapped. The old
+// value must be of a comparable type.
+func (m *Memo[K, V]) CompareAndSwap(k K, old, v V, opts ...SetOption[K, V]) bool {
+	o := m.o.newSetOptions(opts...)
+
+	s, e, now := m.acquire(k)
+	defer s.unlock()
+
+	if cur, ok := present(e); !ok || any(cur) != any(old) {
+		return false
+	}
+
+	m.put(s, e, k, v, o, now)
+
+	return true
+}
+
+// CompareAndDelete deletes the key if its current value is equal to
+// old, it reports whether the key is deleted. The old value must be
+// of a comparable type.
+func (m *Memo[K, V]) CompareAndDelete(k K, old V) bool {
+	s, e, _ := m.acquire(k)
+	defer s.unlock()
+
+	if cur, ok := present(e); !ok || any(cur) != any(old) {
+		return false
+	}
+
+	s.remove(e, Deleted)
+	s.deleteThrough(k)
+
+	return true
+}
+
+// acquire locks the shard of the key and returns it together with the
+// entry of the key(or nil) and the current time. If the entry is being
+// loaded, the lock is released until the load finishes, so that the
+// returned entry always holds a settled value or error.
+func (m *Memo[K, V]) acquire(k K) (*shard[K, V], *entry[K, V], int64) {
+	s := m.shard(k)
+
+	for {
+		now := m.o.clock.Now()
+
+		s.mu.Lock()
+		s.cleanup(now)
+
+		e := s.lookup(k, now)
+		if e == nil || !e.loading() {
+			return s, e, now
+		}
+
+		c := e.call
+		s.unlock()
+		<-c.done
+	}
+}
+
+// present returns the value of the entry and whether it holds one,
+// an entry holding a cached error is regarded as absent.
+func present[K comparable, V any](e *entry[K, V]) (V, bool) {
+	if e == nil || e.err != nil {
+		var zero V
+
+		return zero, false
+	}
+
+	return e.value, true
+}
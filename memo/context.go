@@ -0,0 +1,39 @@
+package memo
+
+import (
+	"context"
+	"errors"
+)
+
+// detach returns a context which has the values and the deadline of
+// ctx, but is not canceled when ctx is canceled, so a load started
+// by a caller can outlive the caller's interest in it.
+func detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	detached := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(detached, deadline)
+	}
+
+	return detached, func() {}
+}
+
+// cacheable reports whether an error returned by the loader should be
+// cached, context errors are never cached, because they are caused by
+// the caller who triggers the load rather than the key itself, and
+// neither are panics and Goexits, so that the next Get retries the load.
+func cacheable(err error, predicate ErrorPredicate) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, ErrGoexit) {
+		return false
+	}
+
+	var pe *PanicError
+	if errors.As(err, &pe) {
+		return false
+	}
+
+	return predicate == nil || predicate(err)
+}
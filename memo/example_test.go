@@ -132,6 +132,40 @@ func Example_concurrency() {
 	// counter: 1
 }
 
+func Example_maxEntries() {
+	m := memo.New(memo.WithMaxEntries[string, int](2))
+	m.Set("x", 1)
+	m.Set("y", 2)
+	fmt.Println(m.Get("x"))
+	m.Set("z", 3)
+	fmt.Println(m.Get("y"))
+	fmt.Println(m.Get("x"))
+
+	// Output:
+	// 1 <nil>
+	// 0 memo: not found
+	// 1 <nil>
+}
+
+func Example_func() {
+	var counter int32
+	add := memo.Func2(func(a, b int) (int, error) {
+		atomic.AddInt32(&counter, 1)
+		return a + b, nil
+	}, memo.WithMaxEntries[memo.Key2[int, int], int](100))
+
+	fmt.Println(add(1, 2))
+	fmt.Println(add(1, 2))
+	fmt.Println(add(2, 1))
+	fmt.Println("counter:", atomic.LoadInt32(&counter))
+
+	// Output:
+	// 3 <nil>
+	// 3 <nil>
+	// 3 <nil>
+	// counter: 2
+}
+
 func length(k string) (int, error) {
 	if k == "error" {
 		return 0, errors.New(k)
@@ -0,0 +1,122 @@
+package memo
+
+import (
+	"container/heap"
+)
+
+// An ExpirationIndex decides how entries are indexed by expiration time,
+// so that expired entries can be found and removed.
+type ExpirationIndex int
+
+const (
+	// Heap is a binary heap ordered by expiration time, inserting,
+	// updating and removing an entry take O(log n) time, and entries
+	// are removed exactly when they expire.
+	Heap ExpirationIndex = iota
+	// TimingWheel is a hierarchical timing wheel, inserting, updating
+	// and removing an entry take O(1) time. Expired entries are never
+	// returned or counted, but they are removed in batches up to about
+	// a millisecond after they expire, so their removal notifications
+	// and memo.Stats may lag behind until then.
+	TimingWheel
+)
+
+// expiry is the actual implementation of ExpirationIndex, entries
+// with a non-zero expireAt are tracked until they are removed.
+type expiry[K comparable, V any] interface {
+	// schedule tracks the entry by its expireAt, or stops tracking
+	// it if expireAt is zero.
+	schedule(e *entry[K, V])
+	// remove stops tracking the entry, it is a no-op if the entry
+	// is not tracked.
+	remove(e *entry[K, V])
+	// expired stops tracking and returns an entry expired at now,
+	// nil is returned if there is none.
+	expired(now int64) *entry[K, V]
+	// next returns the earliest time at which expired may return an
+	// entry, zeroExpireAt is returned if no entry is tracked.
+	next() int64
+}
+
+func newExpiry[K comparable, V any](x ExpirationIndex) expiry[K, V] {
+	if x == TimingWheel {
+		return newTimingWheel[K, V]()
+	}
+
+	return &expiryHeap[K, V]{}
+}
+
+// expiryHeap is a min-heap of entries by expireAt, the position
+// of each entry in the heap is kept in the entry.
+type expiryHeap[K comparable, V any] struct {
+	entries []*entry[K, V]
+}
+
+func (h *expiryHeap[K, V]) schedule(e *entry[K, V]) {
+	switch {
+	case e.position == zeroPosition && e.expireAt != zeroExpireAt:
+		heap.Push(h, e)
+	case e.position != zeroPosition && e.expireAt == zeroExpireAt:
+		heap.Remove(h, e.position)
+	case e.position != zeroPosition:
+		heap.Fix(h, e.position)
+	}
+}
+
+func (h *expiryHeap[K, V]) remove(e *entry[K, V]) {
+	if e.position != zeroPosition {
+		heap.Remove(h, e.position)
+	}
+}
+
+func (h *expiryHeap[K, V]) expired(now int64) *entry[K, V] {
+	if len(h.entries) == 0 || h.entries[0].expireAt > now {
+		return nil
+	}
+
+	e, _ := heap.Pop(h).(*entry[K, V])
+
+	return e
+}
+
+func (h *expiryHeap[K, V]) next() int64 {
+	if len(h.entries) == 0 {
+		return zeroExpireAt
+	}
+
+	return h.entries[0].expireAt
+}
+
+func (h *expiryHeap[K, V]) Len() int {
+	return len(h.entries)
+}
+
+func (h *expiryHeap[K, V]) Less(i, j int) bool {
+	return h.entries[i].expireAt < h.entries[j].expireAt
+}
+
+func (h *expiryHeap[K, V]) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].position = i
+	h.entries[j].position = j
+}
+
+func (h *expiryHeap[K, V]) Push(x any) {
+	e, ok := x.(*entry[K, V])
+	if !ok {
+		panic("memo: heap.Push received an unexpected entry type")
+	}
+
+	e.position = len(h.entries)
+	h.entries = append(h.entries, e)
+}
+
+func (h *expiryHeap[K, V]) Pop() any {
+	n := len(h.entries) - 1
+	e := h.entries[n]
+	h.entries[n] = nil
+	h.entries = h.entries[:n]
+	e.position = zeroPosition
+
+	return e
+}
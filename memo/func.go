@@ -0,0 +1,58 @@
+package memo
+
+// Key2 is a key made of two arguments, see Func2.
+type Key2[T1, T2 comparable] struct {
+	A T1
+	B T2
+}
+
+// Key3 is a key made of three arguments, see Func3.
+type Key3[T1, T2, T3 comparable] struct {
+	A T1
+	B T2
+	C T3
+}
+
+// Func1 returns a function which is the same as fn, except that its
+// results are memoized by the argument, in a memo created with opts,
+// and fn is the loader of the memo. Since the memo is not exposed, it
+// should not be created with WithJanitor, which can not be closed.
+func Func1[T1 comparable, R any](fn func(T1) (R, error), opts ...Option[T1, R]) func(T1) (R, error) {
+	m := New(append(opts[:len(opts):len(opts)], WithLoader(fn))...)
+
+	return func(a T1) (R, error) {
+		return m.Get(a)
+	}
+}
+
+// Func2 is the same as Func1, except that fn has two arguments,
+// which are memoized by a Key2.
+func Func2[T1, T2 comparable, R any](fn func(T1, T2) (R, error), opts ...Option[Key2[T1, T2], R]) func(T1, T2) (R, error) {
+	f := Func1(func(k Key2[T1, T2]) (R, error) { return fn(k.A, k.B) }, opts...)
+
+	return func(a T1, b T2) (R, error) {
+		return f(Key2[T1, T2]{A: a, B: b})
+	}
+}
+
+// Func3 is the same as Func1, except that fn has three arguments,
+// which are memoized by a Key3.
+func Func3[T1, T2, T3 comparable, R any](fn func(T1, T2, T3) (R, error), opts ...Option[Key3[T1, T2, T3], R]) func(T1, T2, T3) (R, error) {
+	f := Func1(func(k Key3[T1, T2, T3]) (R, error) { return fn(k.A, k.B, k.C) }, opts...)
+
+	return func(a T1, b T2, c T3) (R, error) {
+		return f(Key3[T1, T2, T3]{A: a, B: b, C: c})
+	}
+}
+
+// FuncBy is the same as Func1, except that the argument needs not be
+// comparable, its results are memoized by the key derived by key, so
+// arguments with the same key share results. A loader in opts is
+// ignored, since fn is the loader of each call.
+func FuncBy[T any, K comparable, R any](fn func(T) (R, error), key func(T) K, opts ...Option[K, R]) func(T) (R, error) {
+	m := New(opts...)
+
+	return func(a T) (R, error) {
+		return m.Get(key(a), GetWithLoader(func(K) (R, error) { return fn(a) }))
+	}
+}
@@ -0,0 +1,126 @@
+package memo
+
+import (
+	"context"
+	"sync"
+)
+
+// A Group collapses concurrent calls for the same key into one, like
+// a memo without storage, the result is only shared by the callers
+// arriving while the call is in flight. The zero value is ready to use.
+type Group[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*call[V]
+}
+
+// A Result is the result of a call delivered by Group.DoChan.
+type Result[V any] struct {
+	Value V
+	Err   error
+	// Whether the result is shared by multiple callers.
+	Shared bool
+}
+
+// Do calls fn for the key and returns its result, if a call for the key
+// is in flight, it waits for that call instead. The shared result reports
+// whether the result is shared by multiple callers. A panic in fn is
+// returned as a *PanicError, and if fn calls runtime.Goexit, the other
+// callers get ErrGoexit.
+func (g *Group[K, V]) Do(k K, fn func() (V, error)) (V, error, bool) {
+	return g.DoContext(context.Background(), k, func(context.Context) (V, error) {
+		return fn()
+	})
+}
+
+// DoContext is the same as Do, except that the caller can give up waiting
+// for the result when ctx is done, in which case ctx.Err() is returned,
+// while the call continues for other callers. fn receives a context which
+// carries the values and the deadline of ctx, but is not canceled when
+// ctx is canceled.
+func (g *Group[K, V]) DoContext(ctx context.Context, k K, fn func(context.Context) (V, error)) (V, error, bool) {
+	g.mu.Lock()
+
+	if g.calls == nil {
+		g.calls = make(map[K]*call[V])
+	}
+
+	if c, ok := g.calls[k]; ok {
+		c.shared = true
+		g.mu.Unlock()
+
+		v, err := c.wait(ctx)
+
+		return v, err, true
+	}
+
+	c := newCall[V]()
+	g.calls[k] = c
+	g.mu.Unlock()
+
+	// The caller can not give up if ctx is never done, so
+	// there is no need to call in another goroutine.
+	if ctx.Done() == nil {
+		g.call(ctx, k, c, fn)
+
+		return c.value, c.err, c.shared
+	}
+
+	go g.call(ctx, k, c, fn)
+
+	if v, err := c.wait(ctx); !c.settled() {
+		return v, err, false
+	}
+
+	return c.value, c.err, c.shared
+}
+
+// DoChan is the same as Do, except that it returns a channel which
+// receives the result when it is ready.
+func (g *Group[K, V]) DoChan(k K, fn func() (V, error)) <-chan Result[V] {
+	ch := make(chan Result[V], 1)
+
+	// fn may end the goroutine by runtime.Goexit, the
+	// result is still delivered then.
+	go func() {
+		r := Result[V]{Err: ErrGoexit}
+		defer func() { ch <- r }()
+
+		r.Value, r.Err, r.Shared = g.Do(k, fn)
+	}()
+
+	return ch
+}
+
+// Forget makes the next call for the key not wait for the call in
+// flight, which still delivers its result to its current callers.
+func (g *Group[K, V]) Forget(k K) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.calls, k)
+}
+
+// call invokes fn and settles the result, the call is removed
+// from the group before its waiters are woken up.
+func (g *Group[K, V]) call(ctx context.Context, k K, c *call[V], fn func(context.Context) (V, error)) {
+	ctx, cancel := detach(ctx)
+	defer cancel()
+
+	// The result is settled even if fn ends the goroutine by
+	// runtime.Goexit, in which case ErrGoexit is delivered.
+	var v V
+	err := ErrGoexit
+	defer func() {
+		g.mu.Lock()
+
+		if g.calls[k] == c {
+			delete(g.calls, k)
+		}
+
+		g.mu.Unlock()
+
+		c.settle(v, err)
+	}()
+
+	v, err = protect(func() (V, error) { return fn(ctx) })
+}
@@ -0,0 +1,135 @@
+package memo_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rbee3u/golib/memo"
+)
+
+func TestGroup(t *testing.T) {
+	t.Run("Do", func(t *testing.T) {
+		const n = 10
+
+		var g memo.Group[string, int]
+		release := make(chan struct{})
+		var counter int32
+		fn := func() (int, error) {
+			atomic.AddInt32(&counter, 1)
+			<-release
+			return 1, nil
+		}
+
+		var arrived, done sync.WaitGroup
+		for i := 0; i < n; i++ {
+			arrived.Add(1)
+			done.Add(1)
+			go func() {
+				defer done.Done()
+				arrived.Done()
+				if v, err, _ := g.Do("x", fn); v != 1 || err != nil {
+					t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 1, nil)
+				}
+			}()
+		}
+
+		// Let the callers join the call in flight.
+		arrived.Wait()
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+		done.Wait()
+
+		if got := atomic.LoadInt32(&counter); got <= 0 || got >= n {
+			t.Errorf("got: %v, want: (0, %v)", got, n)
+		}
+
+		// The result is not retained.
+		if v, err, shared := g.Do("x", func() (int, error) { return 2, nil }); v != 2 || err != nil || shared {
+			t.Errorf("got: (%v, %v, %v), want: (%v, %v, %v)", v, err, shared, 2, nil, false)
+		}
+	})
+
+	t.Run("DoChan", func(t *testing.T) {
+		var g memo.Group[string, int]
+		r := <-g.DoChan("x", func() (int, error) { return 1, nil })
+		if want := (memo.Result[int]{Value: 1}); r != want {
+			t.Errorf("got: %+v, want: %+v", r, want)
+		}
+	})
+
+	t.Run("Forget", func(t *testing.T) {
+		var g memo.Group[string, int]
+		started, release := make(chan struct{}), make(chan struct{})
+		first := g.DoChan("x", func() (int, error) {
+			close(started)
+			<-release
+			return 1, nil
+		})
+		<-started
+
+		g.Forget("x")
+
+		if v, _, shared := g.Do("x", func() (int, error) { return 2, nil }); v != 2 || shared {
+			t.Errorf("got: (%v, %v), want: (%v, %v)", v, shared, 2, false)
+		}
+
+		close(release)
+		if r := <-first; r.Value != 1 {
+			t.Errorf("got: %v, want: %v", r.Value, 1)
+		}
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		var g memo.Group[string, int]
+		_, err, _ := g.Do("x", func() (int, error) { panic("boom") })
+
+		var pe *memo.PanicError
+		if !errors.As(err, &pe) || pe.Value != "boom" {
+			t.Errorf("got: %v, want: %T", err, pe)
+		}
+	})
+
+	t.Run("Goexit", func(t *testing.T) {
+		var g memo.Group[string, int]
+		r := <-g.DoChan("x", func() (int, error) {
+			runtime.Goexit()
+			return 1, nil
+		})
+
+		if !errors.Is(r.Err, memo.ErrGoexit) {
+			t.Errorf("got: %v, want: %v", r.Err, memo.ErrGoexit)
+		}
+
+		// The key is not left in flight.
+		if v, err, _ := g.Do("x", func() (int, error) { return 2, nil }); v != 2 || err != nil {
+			t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 2, nil)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		var g memo.Group[string, int]
+		release := make(chan struct{})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		fn := func(ctx context.Context) (int, error) {
+			<-release
+			return 1, ctx.Err()
+		}
+
+		if _, err, _ := g.DoContext(ctx, "x", fn); !errors.Is(err, context.Canceled) {
+			t.Errorf("got: %v, want: %v", err, context.Canceled)
+		}
+
+		// The call continues for other callers, with a context not canceled.
+		close(release)
+		if v, err, _ := g.DoContext(context.Background(), "x", fn); v != 1 || err != nil {
+			t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 1, nil)
+		}
+	})
+}
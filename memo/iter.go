@@ -0,0 +1,150 @@
+package memo
+
+import (
+	"iter"
+)
+
+// Len returns the number of key-value pairs in the memo, entries
+// which are expired, being loaded or holding errors are not counted,
+// so it matches the number of pairs All yields.
+func (m *Memo[K, V]) Len() int {
+	now := m.o.clock.Now()
+
+	n := 0
+
+	for _, s := range m.shards {
+		s.mu.Lock()
+		s.cleanup(now)
+
+		for _, e := range s.c.dict {
+			if visible(e, now) {
+				n++
+			}
+		}
+
+		s.unlock()
+	}
+
+	return n
+}
+
+// Clear removes all entries from the memo, the loads in
+// progress are not interrupted, but their values are not
+// stored. Entries which are expired are removed as expired.
+func (m *Memo[K, V]) Clear() {
+	now := m.o.clock.Now()
+
+	for _, s := range m.shards {
+		s.mu.Lock()
+		s.cleanup(now)
+
+		for k := range s.c.dict {
+			if e := s.lookup(k, now); e != nil {
+				s.remove(e, Deleted)
+			}
+		}
+
+		s.unlock()
+	}
+}
+
+// DelFunc removes all key-value pairs for which fn returns true, and
+// returns the number of pairs removed. Entries which are being loaded
+// or holding errors are skipped. fn is called with the shard locked,
+// so it must not access the memo.
+func (m *Memo[K, V]) DelFunc(fn func(K, V) bool) int {
+	now := m.o.clock.Now()
+
+	n := 0
+
+	for _, s := range m.shards {
+		s.mu.Lock()
+		s.cleanup(now)
+
+		for k, e := range s.c.dict {
+			if !e.loading() && e.err == nil && !e.expired(now) && fn(k, e.value) {
+				s.remove(e, Deleted)
+				n++
+			}
+		}
+
+		s.unlock()
+	}
+
+	return n
+}
+
+// InvalidateTag removes all values set with the tag, and returns
+// the number of values removed.
+func (m *Memo[K, V]) InvalidateTag(tag string) int {
+	now := m.o.clock.Now()
+
+	n := 0
+
+	for _, s := range m.shards {
+		s.mu.Lock()
+		s.cleanup(now)
+
+		for k := range s.c.tags[tag] {
+			if e := s.lookup(k, now); e != nil {
+				s.remove(e, Deleted)
+				n++
+			}
+		}
+
+		s.unlock()
+	}
+
+	return n
+}
+
+// All returns an iterator over key-value pairs in the memo, entries
+// which are expired, being loaded or holding errors are skipped. It
+// is safe to modify the memo during iteration, each shard is visited
+// by a snapshot, so modifications may or may not be observed.
+func (m *Memo[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for _, s := range m.shards {
+			for _, p := range m.snapshot(s, m.o.clock.Now()) {
+				if !yield(p.key, p.value) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Keys returns an iterator over keys in the memo, see All.
+func (m *Memo[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for k := range m.All() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// pair is a copy of the key, value and expiration time of an entry.
+type pair[K comparable, V any] struct {
+	key      K
+	value    V
+	expireAt int64
+}
+
+// snapshot returns pairs of entries holding values in the shard.
+func (m *Memo[K, V]) snapshot(s *shard[K, V], now int64) []pair[K, V] {
+	s.mu.Lock()
+	defer s.unlock()
+
+	s.cleanup(now)
+
+	pairs := make([]pair[K, V], 0, len(s.c.dict))
+	for _, e := range s.c.dict {
+		if !e.loading() && e.err == nil && !e.expired(now) {
+			pairs = append(pairs, pair[K, V]{key: e.key, value: e.value, expireAt: e.expireAt})
+		}
+	}
+
+	return pairs
+}
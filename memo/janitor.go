@@ -0,0 +1,81 @@
+package memo
+
+import (
+	"context"
+	"time"
+)
+
+// defaultJanitorInterval is the interval of the janitor run by memo.Serve.
+const defaultJanitorInterval = time.Second
+
+// janitor is a background goroutine started by WithJanitor.
+type janitor struct {
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func (m *Memo[K, V]) startJanitor() {
+	ctx, stop := context.WithCancel(context.Background())
+	m.janitor = &janitor{stop: stop, done: make(chan struct{})}
+
+	go func() {
+		defer close(m.janitor.done)
+		_ = m.serve(ctx, m.o.janitorInterval)
+	}()
+}
+
+// Close stops the janitor started by WithJanitor, and waits for it
+// to exit. The memo is still usable after closing, but expired entries
+// are only removed by other operations. It is safe to call it multiple
+// times, or when no janitor is started.
+func (m *Memo[K, V]) Close() error {
+	if m.janitor != nil {
+		m.janitor.stop()
+		<-m.janitor.done
+	}
+
+	return nil
+}
+
+// Serve runs the janitor until ctx is done, which removes expired entries
+// from all shards when the earliest entry expires, but at least once per
+// second. It makes the memo a suture.Service, so the janitor can run under
+// a supervisor, e.g. runner.Runner, instead of being started by WithJanitor.
+func (m *Memo[K, V]) Serve(ctx context.Context) error {
+	return m.serve(ctx, defaultJanitorInterval)
+}
+
+// serve runs the janitor with the interval until ctx is done.
+func (m *Memo[K, V]) serve(ctx context.Context, interval time.Duration) error {
+	for {
+		now := m.o.clock.Now()
+
+		d := interval
+		if earliest := m.purge(now); earliest != zeroExpireAt {
+			d = min(d, time.Duration(earliest-now))
+		}
+
+		if err := sleepOn(ctx, m.o.clock, d); err != nil {
+			return err
+		}
+	}
+}
+
+// purge removes expired entries from all shards, and returns the
+// earliest expiration time of the remaining entries.
+func (m *Memo[K, V]) purge(now int64) int64 {
+	earliest := int64(zeroExpireAt)
+
+	for _, s := range m.shards {
+		s.mu.Lock()
+		s.cleanup(now)
+
+		if next := s.c.expiryNext(); next != zeroExpireAt && (earliest == zeroExpireAt || next < earliest) {
+			earliest = next
+		}
+
+		s.unlock()
+	}
+
+	return earliest
+}
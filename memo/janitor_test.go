@@ -0,0 +1,81 @@
+package memo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thejerf/suture/v4"
+
+	"github.com/rbee3u/golib/memo"
+)
+
+var _ suture.Service = (*memo.Memo[string, int])(nil)
+
+func TestJanitor(t *testing.T) {
+	mc := memo.NewManualClock()
+	expired := make(chan string, 2)
+	onRemove := func(k string, _ int, reason memo.RemovalReason) {
+		if reason == memo.Expired {
+			expired <- k
+		}
+	}
+
+	m := memo.New(
+		memo.WithClock[string, int](mc),
+		memo.WithJanitor[string, int](time.Minute),
+		memo.WithOnRemove(onRemove),
+	)
+	defer m.Close()
+
+	m.Set("a", 1, memo.SetWithExpiration[string, int](10*time.Second))
+	m.Set("b", 2, memo.SetWithExpiration[string, int](90*time.Second))
+
+	// The janitor wakes up after the interval.
+	mc.BlockUntil(1)
+	mc.Advance(time.Minute)
+	if k := <-expired; k != "a" {
+		t.Errorf("got: %v, want: %v", k, "a")
+	}
+
+	// The janitor wakes up when the earliest entry expires.
+	mc.BlockUntil(1)
+	mc.Advance(30 * time.Second)
+	if k := <-expired; k != "b" {
+		t.Errorf("got: %v, want: %v", k, "b")
+	}
+
+	if err := m.Close(); err != nil {
+		t.Errorf("got: %v, want: %v", err, nil)
+	}
+
+	if n := mc.Sleepers(); n != 0 {
+		t.Errorf("got: %v, want: %v", n, 0)
+	}
+}
+
+func TestServe(t *testing.T) {
+	mc := memo.NewManualClock()
+	m := memo.New(memo.WithClock[string, int](mc))
+	m.Set("a", 1, memo.SetWithExpiration[string, int](time.Second))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- m.Serve(ctx)
+	}()
+
+	mc.BlockUntil(1)
+	mc.Advance(time.Second)
+	mc.BlockUntil(1)
+
+	if n := m.Stats().Expirations; n != 1 {
+		t.Errorf("got: %v, want: %v", n, 1)
+	}
+
+	cancel()
+	if err := <-served; !errors.Is(err, context.Canceled) {
+		t.Errorf("got: %v, want: %v", err, context.Canceled)
+	}
+}
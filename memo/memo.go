@@ -1,251 +1,612 @@
 package memo
 
 import (
-	"container/heap"
-	"sync"
+	"context"
+	"hash/maphash"
+	"time"
 )
 
 // Memo is an in-memory k-v storage, which supports concurrently
 // get/set/delete k-v pairs. The most special place is that it
 // can load value if not found, and can set an expiration time.
+//
+// Keys are partitioned into shards by hash, each shard has its own
+// lock and storage, so operations on different shards never block
+// each other.
 type Memo[K comparable, V any] struct {
-	mu sync.Mutex
-	o  options[K, V]
-	c  *cache[K, V]
+	o        options[K, V]
+	seed     maphash.Seed
+	shards   []*shard[K, V]
+	counters counters
+	janitor  *janitor
+	tier     *tier[K, V]
 }
 
 // New creates a memo with options.
 func New[K comparable, V any](opts ...Option[K, V]) *Memo[K, V] {
-	return &Memo[K, V]{
-		o: newOptions[K, V](opts...),
-		c: newCache[K, V](),
+	o := newOptions[K, V](opts...)
+
+	l := limit{entries: o.maxEntries, weight: o.maxWeight}
+	t := newTier(o.store, o.onStoreError)
+
+	shards := make([]*shard[K, V], o.shards)
+	for i := range shards {
+		p := newPolicy[K, V](o.policy, l.share(i, o.shards))
+		shards[i] = newShard[K, V](p, newExpiry[K, V](o.expirationIndex), o.onRemove, t)
+	}
+
+	m := &Memo[K, V]{o: o, seed: maphash.MakeSeed(), shards: shards, tier: t}
+	if o.janitorInterval != 0 {
+		m.startJanitor()
+	}
+
+	return m
+}
+
+// shard returns the shard which the key belongs to.
+func (m *Memo[K, V]) shard(k K) *shard[K, V] {
+	if len(m.shards) == 1 {
+		return m.shards[0]
 	}
+
+	return m.shards[maphash.Comparable(m.seed, k)%uint64(len(m.shards))]
 }
 
 // Get returns the associated value of the key.
-// If the value is not found(or expired) but a loader is provided,
-// the loader will be invoked to get a new value.
+// If the value is not found(or expired) but a store is provided, the
+// value will be got from the store, and if it is not found there but
+// a loader is provided, the loader will be invoked to get a new value.
 // If a new value is loaded and an expiration option is provided,
-// the expiration option will act on the new value.
+// the expiration option will act on the new value, unless the loader
+// is a LoaderWithTTL, which decides the expiration itself.
+// If a refresh interval is provided and the value is older than it,
+// the value is returned immediately, and a background reload will
+// be triggered, which replaces the value when it succeeds.
 func (m *Memo[K, V]) Get(k K, opts ...GetOption[K, V]) (V, error) {
-	o := m.o.newGetOptions(opts...)
-	now := m.o.clock.Now()
+	return m.GetContext(context.Background(), k, opts...)
+}
 
-	var expireAt int64
-	if o.expiration != 0 {
-		expireAt = now + int64(o.expiration)
-	}
+// GetContext is the same as Get, except that the caller can give up
+// waiting for the value when ctx is done, in which case ctx.Err() is
+// returned, while the load continues for other callers. The loader
+// receives a context which carries the values and the deadline of
+// ctx, but is not canceled when ctx is canceled, and context errors
+// returned by the loader are never cached.
+func (m *Memo[K, V]) GetContext(ctx context.Context, k K, opts ...GetOption[K, V]) (V, error) {
+	return m.get(ctx, k, m.o.newGetOptions(opts...), nil)
+}
+
+// get returns the associated value of the key, and describes the
+// value in meta if it is not nil and no error is returned.
+func (m *Memo[K, V]) get(ctx context.Context, k K, o getOptions[K, V], meta *Meta) (V, error) {
+	now := m.o.clock.Now()
 
-	m.mu.Lock()
-	m.cleanup(now)
+	s := m.shard(k)
+	s.mu.Lock()
+	s.cleanup(now)
 
-	e := m.c.dictGet(k)
+	e := s.lookup(k, now)
 	if e != nil {
-		m.mu.Unlock()
-		e.mu.Lock()
-		defer e.mu.Unlock()
+		s.counters.hits.Add(1)
+		s.c.policyAccess(e)
+
+		if c := e.call; c != nil {
+			s.unlock()
 
-		return e.value, e.err
+			v, err := c.wait(ctx)
+			if err == nil && meta != nil {
+				*meta = m.describe(s, e)
+			}
+
+			return v, err
+		}
+
+		m.slide(s, e, o, now)
+		refresh := m.refreshable(e, o, now)
+		v, err, version := e.value, e.err, e.version
+		if err == nil && meta != nil {
+			*meta = m.meta(s, e, now)
+		}
+		s.unlock()
+
+		if refresh {
+			go m.refresh(context.WithoutCancel(ctx), s, e, o, version)
+		}
+
+		return v, err
 	}
 
-	if o.loader == nil {
-		m.mu.Unlock()
+	s.counters.misses.Add(1)
+
+	if !o.loads() && m.tier == nil {
+		s.unlock()
 
 		var zero V
 
 		return zero, ErrNotFound
 	}
 
-	e = newEntry[V]()
-	m.c.dictSet(k, e)
-	m.c.heapPush(node[K]{key: k, expireAt: expireAt})
+	e = newEntry[K, V](k)
+	c := newCall[V]()
+	e.call = c
+	e.refreshAt = m.refreshAt(now)
+	s.c.dictSet(k, e)
+	s.expire(e, now, o.expiration)
+	s.unlock()
+
+	// The caller can not give up if ctx is never done, so
+	// there is no need to load in another goroutine.
+	var v V
+	var err error
+
+	if ctx.Done() == nil {
+		v, err = m.load(ctx, s, e, o)
+	} else {
+		go func() {
+			_, _ = m.load(ctx, s, e, o)
+		}()
+
+		v, err = c.wait(ctx)
+	}
 
-	e.mu.Lock()
-	m.mu.Unlock()
-	defer e.mu.Unlock()
-	e.value, e.err = o.loader(k)
+	if err == nil && meta != nil {
+		*meta = m.describe(s, e)
+	}
 
-	return e.value, e.err
+	return v, err
 }
 
 // Set inserts a key-value pair into the memo, if the key
 // already exists, update the associated value directly.
 // If an expiration is provided, it will act on the pair.
+// If a store is provided, the pair is written through.
 func (m *Memo[K, V]) Set(k K, v V, opts ...SetOption[K, V]) {
 	o := m.o.newSetOptions(opts...)
 	now := m.o.clock.Now()
 
-	var expireAt int64
-	if o.expiration != 0 {
-		expireAt = now + int64(o.expiration)
+	s := m.shard(k)
+	s.mu.Lock()
+	defer s.unlock()
+	s.cleanup(now)
+
+	e := s.lookup(k, now)
+	if e != nil && e.loading() {
+		// The value being loaded is out of date, its
+		// waiters still get it, but it is not stored.
+		s.remove(e, Replaced)
+		e = nil
 	}
 
-	m.mu.Lock()
-	m.cleanup(now)
+	m.put(s, e, k, v, o, now)
+}
 
-	e := m.c.dictGet(k)
+// put sets the value of the key, e is the current entry of the
+// key which is not being loaded, or nil if it is absent.
+func (m *Memo[K, V]) put(s *shard[K, V], e *entry[K, V], k K, v V, o setOptions[K, V], now int64) {
 	if e == nil {
-		e = newEntry[V]()
-		e.value = v
-		m.c.dictSet(k, e)
-		m.c.heapPush(node[K]{key: k, expireAt: expireAt})
-		m.mu.Unlock()
+		m.insert(s, k, v, o, now)
+		s.writeThrough(k, v, o.expiration)
 
 		return
 	}
 
-	m.c.heapFix(e.position, node[K]{key: k, expireAt: expireAt})
-
-	m.mu.Unlock()
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	s.record(e, Replaced)
+	s.expire(e, now, o.expiration)
+	s.c.policyAccess(e)
+	s.c.tag(e, o.tags)
+	e.loadedAt = now
+	e.refreshAt = m.refreshAt(now)
 	e.value, e.err = v, nil
+	e.version++
+	s.c.weigh(e, m.weigh(k, v))
+	s.evict()
+	s.writeThrough(k, v, o.expiration)
 }
 
-// Del removes the key-value pair from the memo.
-func (m *Memo[K, V]) Del(k K) {
+// insert adds a new entry of the key-value pair, the key must be
+// absent, s.mu must be held.
+func (m *Memo[K, V]) insert(s *shard[K, V], k K, v V, o setOptions[K, V], now int64) {
+	e := newEntry[K, V](k)
+	e.value = v
+	e.loadedAt = now
+	e.weight = m.weigh(k, v)
+	e.refreshAt = m.refreshAt(now)
+	s.c.dictSet(k, e)
+	s.c.tag(e, o.tags)
+	s.expire(e, now, o.expiration)
+	s.c.policyAdd(e)
+	s.evict()
+}
+
+// load gets the value of the entry from the store, or invokes the
+// loader for it and writes the loaded value to the store, and settles
+// the result.
+func (m *Memo[K, V]) load(ctx context.Context, s *shard[K, V], e *entry[K, V], o getOptions[K, V]) (V, error) {
+	ctx, cancel := detach(ctx)
+	defer cancel()
+	defer m.abandon(s, e, o)
+
+	v, ttl, err := m.tier.get(ctx, e.key)
+	if err == nil || !o.loads() {
+		// Without a loader, a miss or a failure of the store is
+		// not cached, so a value written to it later is seen.
+		o.expiration = within(o.expiration, ttl)
+		o.errorPredicate = func(error) bool { return false }
+		m.settle(s, e, o, v, err, false)
+
+		return v, err
+	}
+
+	start := m.o.clock.Now()
+	v, ttl, err = m.invoke(ctx, e.key, o)
+	m.recordLoad(start, err)
+
+	if err == nil {
+		o.expiration = ttl
+	}
+
+	m.settle(s, e, o, v, err, true)
+
+	return v, err
+}
+
+// invoke invokes the loader with retries, and recovers its panics.
+func (m *Memo[K, V]) invoke(ctx context.Context, k K, o getOptions[K, V]) (V, time.Duration, error) {
+	var ttl time.Duration
+
+	v, err := retry(ctx, m.o.clock, m.o.retryPolicy, func() (V, error) {
+		return protect(func() (v V, err error) {
+			v, ttl, err = o.load(ctx, k)
+
+			return v, err
+		})
+	})
+
+	return v, ttl, err
+}
+
+// abandon settles the entry with ErrGoexit if it is still loading, which
+// happens when the store or the loader ends the loading goroutine by
+// runtime.Goexit, so that the waiters are not blocked forever. e.call is
+// only cleared by the loading goroutine, so it is read without s.mu.
+func (m *Memo[K, V]) abandon(s *shard[K, V], e *entry[K, V], o getOptions[K, V]) {
+	if e.loading() {
+		var zero V
+
+		m.settle(s, e, o, zero, ErrGoexit, false)
+	}
+}
+
+// settle stores the loaded result into the entry and wakes up its
+// waiters, the cache is left untouched if the entry has been removed
+// or replaced during the load. A value kept in the memo is written
+// through to the store if through is set, in the same critical section
+// so that it is ordered with the other writes of the key.
+func (m *Memo[K, V]) settle(s *shard[K, V], e *entry[K, V], o getOptions[K, V], v V, err error, through bool) {
 	now := m.o.clock.Now()
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.cleanup(now)
 
-	e := m.c.dictGet(k)
-	if e == nil {
+	s.mu.Lock()
+	defer s.unlock()
+
+	e.value, e.err = v, err
+	e.loadedAt = now
+
+	// A result not to be cached is removed while it is
+	// loading, so that its removal is not notified.
+	kept := s.c.dictGet(e.key) == e
+	if kept && (err == nil && o.expiration < 0 || err != nil && !cacheable(err, o.errorPredicate)) {
+		s.remove(e, Deleted)
+		kept = false
+	}
+
+	// The entry is tracked by the policy only once the result is kept,
+	// so that loads in progress never push out other entries.
+	if kept {
+		switch {
+		case err == nil:
+			// The expiration may be decided by the store or the loader.
+			if e.lifetime != o.expiration {
+				s.expire(e, now, o.expiration)
+			}
+
+			s.c.weigh(e, m.weigh(e.key, v))
+		case o.errorExpirationSet:
+			s.expire(e, now, o.errorExpiration)
+		}
+
+		s.c.policyAdd(e)
+	}
+
+	c := e.call
+	e.call = nil
+	c.settle(v, err)
+
+	if !kept {
 		return
 	}
 
-	m.c.heapRemove(e.position)
-	m.c.dictDel(k)
+	s.evict()
+
+	if err == nil && through {
+		s.writeThrough(e.key, v, o.expiration)
+	}
+}
+
+// refreshable reports whether a refresh should be triggered for the
+// entry, and marks it as refreshing if so, s.mu must be held.
+func (m *Memo[K, V]) refreshable(e *entry[K, V], o getOptions[K, V], now int64) bool {
+	if !o.loads() || e.refreshing || e.refreshAt == zeroRefreshAt || e.refreshAt > now {
+		return false
+	}
+
+	e.refreshing = true
+
+	return true
 }
 
-func (m *Memo[K, V]) cleanup(now int64) {
-	for !m.c.heapEmpty() {
-		top := m.c.heapTop()
-		if top.expireAt > now {
-			break
+// slide pushes the expiration time of the entry forward on a hit if the
+// sliding expiration is enabled, errors never slide, s.mu must be held.
+func (m *Memo[K, V]) slide(s *shard[K, V], e *entry[K, V], o getOptions[K, V], now int64) {
+	if o.slidingExpiration && e.err == nil && e.lifetime != 0 {
+		s.expire(e, now, e.lifetime)
+	}
+}
+
+// refresh reloads the value of the entry in background, the entry is
+// updated only if it is still in the memo with the value of version
+// when the load succeeds.
+func (m *Memo[K, V]) refresh(ctx context.Context, s *shard[K, V], e *entry[K, V], o getOptions[K, V], version uint64) {
+	// The entry can be refreshed again even if the loader
+	// ends the goroutine by runtime.Goexit.
+	returned := false
+	defer func() {
+		if !returned {
+			s.mu.Lock()
+			e.refreshing = false
+			s.unlock()
 		}
+	}()
+
+	start := m.o.clock.Now()
+	v, ttl, err := m.invoke(ctx, e.key, o)
+	returned = true
+	m.recordLoad(start, err)
+	now := m.o.clock.Now()
+
+	s.mu.Lock()
+	defer s.unlock()
+
+	e.refreshing = false
+
+	// The value set during the refresh is newer.
+	if err != nil || s.c.dictGet(e.key) != e || e.version != version {
+		return
+	}
+
+	// The stale value is not kept either.
+	if ttl < 0 {
+		s.remove(e, Deleted)
+
+		return
+	}
+
+	s.writeThrough(e.key, v, ttl)
+	s.record(e, Replaced)
+	s.expire(e, now, ttl)
+	e.loadedAt = now
+	e.refreshAt = m.refreshAt(now)
+	e.value, e.err = v, nil
+	e.version++
+	s.c.weigh(e, m.weigh(e.key, v))
+	s.evict()
+}
+
+// weigh returns the weight of the key-value pair, 1 by default.
+func (m *Memo[K, V]) weigh(k K, v V) int64 {
+	if m.o.weigher == nil {
+		return 1
+	}
+
+	return max(0, m.o.weigher(k, v))
+}
+
+// refreshAt returns the time to refresh a value loaded or set at now.
+func (m *Memo[K, V]) refreshAt(now int64) int64 {
+	if m.o.refreshInterval == 0 {
+		return zeroRefreshAt
+	}
+
+	return now + int64(m.o.refreshInterval)
+}
+
+// Del removes the key-value pair from the memo.
+// If a store is provided, the key is deleted through.
+func (m *Memo[K, V]) Del(k K) {
+	now := m.o.clock.Now()
+	s := m.shard(k)
+	s.mu.Lock()
+	defer s.unlock()
+	s.cleanup(now)
+	s.deleteThrough(k)
 
-		m.c.heapPop()
-		m.c.dictDel(top.key)
+	e := s.lookup(k, now)
+	if e == nil {
+		return
 	}
+
+	s.remove(e, Deleted)
 }
 
 // cache is the actual storage layer of memo.
 type cache[K comparable, V any] struct {
 	// A dict supports lookup value by key quickly.
-	dict map[K]*entry[V]
-	// A heap to hold all expiration time of keys.
-	heap []node[K]
-	// The size of the heap.
-	heapSize int
+	dict map[K]*entry[K, V]
+	// An index to find expired entries.
+	expiry expiry[K, V]
+	// A policy to decide which entry should be evicted.
+	policy policy[K, V]
+	// The total weight of entries.
+	weight int64
+	// An index from tags to the keys of tagged entries.
+	tags map[string]map[K]struct{}
 }
 
-func newCache[K comparable, V any]() *cache[K, V] {
-	return &cache[K, V]{dict: make(map[K]*entry[V])}
+func newCache[K comparable, V any](p policy[K, V], x expiry[K, V]) *cache[K, V] {
+	return &cache[K, V]{dict: make(map[K]*entry[K, V]), expiry: x, policy: p, tags: make(map[string]map[K]struct{})}
 }
 
-const zeroPosition = -1
+// expireAt returns the expiration time of a value loaded or set at now.
+func expireAt(now int64, expiration time.Duration) int64 {
+	if expiration == 0 {
+		return zeroExpireAt
+	}
+
+	return now + int64(expiration)
+}
 
-type entry[V any] struct {
-	mu       sync.Mutex
+const (
+	zeroPosition  = -1
+	zeroRefreshAt = 0
+)
+
+type entry[K comparable, V any] struct {
+	key K
+	// The time the entry expires, and its position in
+	// the expiration index, which is a slot for a wheel.
+	expireAt int64
 	position int
-	value    V
-	err      error
+	tprev    *entry[K, V]
+	tnext    *entry[K, V]
+	prev     *entry[K, V]
+	next     *entry[K, V]
+	segment  uint8
+	// The weight, which is 1 for errors and values being loaded.
+	weight int64
+	// The expiration the value is set or loaded with,
+	// and the time it is set or loaded.
+	lifetime time.Duration
+	loadedAt int64
+	// The time to refresh the value in background, and
+	// whether a refresh is in progress.
+	refreshAt  int64
+	refreshing bool
+	// The count of the values set in place, which tells a
+	// refresh whether the value it replaces is still current.
+	version uint64
+	// The load in progress, which is nil once it settles.
+	call  *call[V]
+	value V
+	err   error
+	// The tags the value is set with.
+	tags []string
 }
 
-func newEntry[V any]() *entry[V] {
-	return &entry[V]{position: zeroPosition}
+func newEntry[K comparable, V any](k K) *entry[K, V] {
+	return &entry[K, V]{key: k, position: zeroPosition, weight: 1}
 }
 
 const zeroExpireAt = 0
 
-type node[K comparable] struct {
-	key      K
-	expireAt int64
+// loading reports whether the value of the entry is being loaded.
+func (e *entry[K, V]) loading() bool {
+	return e.call != nil
 }
 
-func newNode[K comparable]() node[K] {
-	return node[K]{}
+// expired reports whether the entry is expired at now.
+func (e *entry[K, V]) expired(now int64) bool {
+	return e.expireAt != zeroExpireAt && e.expireAt <= now
 }
 
-func (c *cache[K, V]) dictGet(k K) *entry[V] {
+func (c *cache[K, V]) dictGet(k K) *entry[K, V] {
 	return c.dict[k]
 }
 
-func (c *cache[K, V]) dictSet(k K, e *entry[V]) {
+func (c *cache[K, V]) dictSet(k K, e *entry[K, V]) {
 	c.dict[k] = e
+	c.weight += e.weight
 }
 
 func (c *cache[K, V]) dictDel(k K) {
+	c.untag(c.dict[k])
+	c.weight -= c.dict[k].weight
 	delete(c.dict, k)
 }
 
-func (c *cache[K, V]) heapEmpty() bool {
-	return c.heapSize == 0
-}
+// tag replaces the tags of the entry and indexes it by them.
+func (c *cache[K, V]) tag(e *entry[K, V], tags []string) {
+	c.untag(e)
+	e.tags = tags
 
-func (c *cache[K, V]) heapTop() node[K] {
-	return c.heap[0]
-}
+	for _, t := range tags {
+		keys := c.tags[t]
+		if keys == nil {
+			keys = make(map[K]struct{})
+			c.tags[t] = keys
+		}
 
-func (c *cache[K, V]) heapPop() {
-	heap.Pop(c)
+		keys[e.key] = struct{}{}
+	}
 }
 
-func (c *cache[K, V]) heapPush(n node[K]) {
-	c.heapFix(zeroPosition, n)
-}
+// untag removes the entry from the index of its tags.
+func (c *cache[K, V]) untag(e *entry[K, V]) {
+	for _, t := range e.tags {
+		delete(c.tags[t], e.key)
+
+		if len(c.tags[t]) == 0 {
+			delete(c.tags, t)
+		}
+	}
 
-func (c *cache[K, V]) heapRemove(i int) {
-	c.heapFix(i, node[K]{})
+	e.tags = nil
 }
 
-func (c *cache[K, V]) heapFix(i int, n node[K]) {
-	switch {
-	case i == zeroPosition && n.expireAt != zeroExpireAt:
-		heap.Push(c, n)
-	case i != zeroPosition && n.expireAt == zeroExpireAt:
-		heap.Remove(c, i)
-	case i != zeroPosition && c.heap[i].expireAt != n.expireAt:
-		c.heap[i].expireAt = n.expireAt
-		heap.Fix(c, i)
+// weigh changes the weight of the entry in the dict to w.
+func (c *cache[K, V]) weigh(e *entry[K, V], w int64) {
+	c.weight += w - e.weight
+
+	if e.loading() {
+		e.weight = w
+	} else {
+		c.policy.reweigh(e, w)
 	}
 }
 
-func (c *cache[K, V]) Len() int {
-	return c.heapSize
+// policyAdd tracks the entry by the policy, entries being loaded
+// are not tracked until they are settled, see Memo.settle.
+func (c *cache[K, V]) policyAdd(e *entry[K, V]) {
+	c.policy.add(e)
 }
 
-func (c *cache[K, V]) Less(i, j int) bool {
-	return c.heap[i].expireAt < c.heap[j].expireAt
+func (c *cache[K, V]) policyAccess(e *entry[K, V]) {
+	if !e.loading() {
+		c.policy.access(e)
+	}
 }
 
-func (c *cache[K, V]) Swap(i, j int) {
-	if i != j {
-		c.heap[i], c.heap[j] = c.heap[j], c.heap[i]
-		c.dict[c.heap[i].key].position = i
-		c.dict[c.heap[j].key].position = j
+func (c *cache[K, V]) policyRemove(e *entry[K, V]) {
+	if !e.loading() {
+		c.policy.remove(e)
 	}
 }
 
-func (c *cache[K, V]) Push(n interface{}) {
-	if c.heapSize == len(c.heap) {
-		c.heap = append(c.heap, newNode[K]())
-	}
+func (c *cache[K, V]) policyEvict() *entry[K, V] {
+	return c.policy.evict()
+}
 
-	heapNode, ok := n.(node[K])
-	if !ok {
-		panic("memo: heap.Push received an unexpected node type")
-	}
+func (c *cache[K, V]) expirySchedule(e *entry[K, V], expireAt int64) {
+	e.expireAt = expireAt
+	c.expiry.schedule(e)
+}
 
-	c.heap[c.heapSize] = heapNode
-	c.dict[c.heap[c.heapSize].key].position = c.heapSize
-	c.heapSize++
+func (c *cache[K, V]) expiryRemove(e *entry[K, V]) {
+	c.expiry.remove(e)
 }
 
-func (c *cache[K, V]) Pop() interface{} {
-	c.heapSize--
-	c.dict[c.heap[c.heapSize].key].position = zeroPosition
+func (c *cache[K, V]) expiryPop(now int64) *entry[K, V] {
+	return c.expiry.expired(now)
+}
 
-	return c.heap[c.heapSize]
+func (c *cache[K, V]) expiryNext() int64 {
+	return c.expiry.next()
 }
@@ -1,10 +1,20 @@
 package memo_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"math"
 	"math/rand"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -12,13 +22,37 @@ import (
 )
 
 func TestMemo(t *testing.T) {
-	fc := newFakeClock()
+	for _, shards := range []int{1, 8} {
+		for _, index := range expirationIndexes {
+			t.Run(fmt.Sprintf("Shards%v/%v", shards, index.name), func(t *testing.T) {
+				testMemo(t, shards, index.index)
+			})
+		}
+	}
+}
+
+var expirationIndexes = []struct {
+	name  string
+	index memo.ExpirationIndex
+}{
+	{name: "Heap", index: memo.Heap},
+	{name: "TimingWheel", index: memo.TimingWheel},
+}
+
+func testMemo(t *testing.T, shards int, index memo.ExpirationIndex) {
+	fc := memo.NewManualClock()
 	g := &generator{r: rand.New(rand.NewSource(142857677367)), mk: 100, mv: 1000000000}
-	m := memo.New(memo.WithClock[int, int](fc), memo.WithLoader[int, int](nil), memo.WithExpiration[int, int](0))
+	m := memo.New(
+		memo.WithClock[int, int](fc),
+		memo.WithLoader[int, int](nil),
+		memo.WithExpiration[int, int](0),
+		memo.WithShards[int, int](shards),
+		memo.WithExpirationIndex[int, int](index),
+	)
 	c := &competitor{clock: fc, dict: make(map[int]*entry)}
 
 	for i := 0; i < 100000; i++ {
-		fc.advance(time.Second)
+		fc.Advance(time.Second)
 		switch op := g.next().(type) {
 		case opGet:
 			var loader func(int) (int, error)
@@ -56,6 +90,12 @@ func TestInvalidExpiration(t *testing.T) {
 		{name: "Get", exec: func() {
 			_, _ = memo.New[int, int]().Get(0, memo.GetWithExpiration[int, int](-1))
 		}},
+		{name: "NewError", exec: func() {
+			_ = memo.New(memo.WithErrorExpiration[int, int](-1))
+		}},
+		{name: "GetError", exec: func() {
+			_, _ = memo.New[int, int]().Get(0, memo.GetWithErrorExpiration[int, int](-1))
+		}},
 		{name: "Set", exec: func() {
 			memo.New[int, int]().Set(0, 0, memo.SetWithExpiration[int, int](-1))
 		}},
@@ -74,70 +114,1731 @@ func TestInvalidExpiration(t *testing.T) {
 	}
 }
 
-func BenchmarkMemo_Get(b *testing.B) {
-	b.Run("FastPath", func(b *testing.B) {
-		m := memo.New[string, string]()
-		b.RunParallel(func(pb *testing.PB) {
-			for pb.Next() {
-				_, _ = m.Get("k")
+func TestInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []memo.Option[int, int]
+		want error
+	}{
+		{name: "MaxEntries", opts: []memo.Option[int, int]{
+			memo.WithMaxEntries[int, int](-1),
+		}, want: memo.ErrInvalidMaxEntries},
+		{name: "MaxWeight", opts: []memo.Option[int, int]{
+			memo.WithMaxWeight[int, int](-1),
+		}, want: memo.ErrInvalidMaxWeight},
+		{name: "Policy", opts: []memo.Option[int, int]{
+			memo.WithPolicy[int, int](-1),
+		}, want: memo.ErrInvalidPolicy},
+		{name: "Shards", opts: []memo.Option[int, int]{
+			memo.WithShards[int, int](0),
+		}, want: memo.ErrInvalidShards},
+		{name: "ShardsOverMaxEntries", opts: []memo.Option[int, int]{
+			memo.WithMaxEntries[int, int](3), memo.WithShards[int, int](4),
+		}, want: memo.ErrInvalidShards},
+		{name: "ShardsOverMaxWeight", opts: []memo.Option[int, int]{
+			memo.WithMaxWeight[int, int](3), memo.WithShards[int, int](4),
+		}, want: memo.ErrInvalidShards},
+		{name: "RefreshInterval", opts: []memo.Option[int, int]{
+			memo.WithRefreshInterval[int, int](-1),
+		}, want: memo.ErrInvalidRefreshInterval},
+		{name: "JanitorInterval", opts: []memo.Option[int, int]{
+			memo.WithJanitor[int, int](-1),
+		}, want: memo.ErrInvalidJanitorInterval},
+		{name: "ExpirationIndex", opts: []memo.Option[int, int]{
+			memo.WithExpirationIndex[int, int](-1),
+		}, want: memo.ErrInvalidExpirationIndex},
+		{name: "RetryPolicy", opts: []memo.Option[int, int]{
+			memo.WithLoadRetry[int, int](memo.RetryPolicy{}),
+		}, want: memo.ErrInvalidRetryPolicy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				err, _ := recover().(error)
+				if !errors.Is(err, tt.want) {
+					t.Errorf("got: %v, want: %v", err, tt.want)
+				}
+			}()
+			_ = memo.New(tt.opts...)
+		})
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	fc := memo.NewManualClock()
+	m := memo.New(memo.WithClock[string, int](fc), memo.WithMaxEntries[string, int](2))
+
+	m.Set("a", 1)
+	m.Set("b", 2, memo.SetWithExpiration[string, int](time.Minute))
+	_, _ = m.Get("a")
+	m.Set("c", 3)
+
+	tests := []struct {
+		k   string
+		v   int
+		err error
+	}{
+		{k: "a", v: 1},
+		{k: "b", err: memo.ErrNotFound},
+		{k: "c", v: 3},
+	}
+
+	for _, tt := range tests {
+		v, err := m.Get(tt.k)
+		if v != tt.v || !errors.Is(err, tt.err) {
+			t.Errorf("%v: got: (%v, %v), want: (%v, %v)", tt.k, v, err, tt.v, tt.err)
+		}
+	}
+
+	m.Set("d", 4, memo.SetWithExpiration[string, int](time.Minute))
+	fc.Advance(time.Minute)
+	m.Set("e", 5)
+
+	for k, want := range map[string]int{"c": 3, "e": 5} {
+		if v, err := m.Get(k); v != want || err != nil {
+			t.Errorf("%v: got: (%v, %v), want: (%v, %v)", k, v, err, want, nil)
+		}
+	}
+}
+
+func TestMaxWeight(t *testing.T) {
+	m := memo.New(
+		memo.WithMaxWeight[string, string](10),
+		memo.WithWeigher(func(_ string, v string) int64 { return int64(len(v)) }),
+	)
+
+	m.Set("a", "aaaa")
+	m.Set("b", "bbbb")
+	_, _ = m.Get("a")
+	m.Set("c", "cccc")
+
+	tests := []struct {
+		k   string
+		v   string
+		err error
+	}{
+		{k: "a", v: "aaaa"},
+		{k: "b", err: memo.ErrNotFound},
+		{k: "c", v: "cccc"},
+	}
+
+	for _, tt := range tests {
+		v, err := m.Get(tt.k)
+		if v != tt.v || !errors.Is(err, tt.err) {
+			t.Errorf("%v: got: (%v, %v), want: (%v, %v)", tt.k, v, err, tt.v, tt.err)
+		}
+	}
+
+	m.Set("a", "aaaaaaaa")
+
+	if _, err := m.Get("c"); !errors.Is(err, memo.ErrNotFound) {
+		t.Errorf("c: got: %v, want: %v", err, memo.ErrNotFound)
+	}
+
+	if got := m.Stats(); got.Weight != 8 || got.Evictions != 2 {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", got.Weight, got.Evictions, 8, 2)
+	}
+}
+
+func TestShardLimit(t *testing.T) {
+	m := memo.New(
+		memo.WithMaxEntries[int, int](10),
+		memo.WithMaxWeight[int, int](30),
+		memo.WithWeigher(func(int, int) int64 { return 2 }),
+		memo.WithShards[int, int](4),
+	)
+
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+
+	// The shards hold no more than the limits altogether.
+	if got := m.Stats(); m.Len() != 10 || got.Weight != 20 {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", m.Len(), got.Weight, 10, 20)
+	}
+
+}
+
+func TestMaxEntriesNotCached(t *testing.T) {
+	errTransient := errors.New("transient")
+
+	tests := []struct {
+		name string
+		opts []memo.Option[string, int]
+		get  []memo.GetOption[string, int]
+	}{
+		{name: "DontCache", get: []memo.GetOption[string, int]{
+			memo.GetWithLoaderWithTTL(func(string) (int, time.Duration, error) { return 1, memo.DontCache, nil }),
+		}},
+		{name: "ErrorPredicate", get: []memo.GetOption[string, int]{
+			memo.GetWithLoader(func(string) (int, error) { return 0, errTransient }),
+			memo.GetWithErrorPredicate[string, int](func(error) bool { return false }),
+		}},
+		{name: "StoreMiss", opts: []memo.Option[string, int]{
+			memo.WithStore[string, int](memo.NewMapStore[string, int](memo.NewRealClock())),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := memo.New(append(tt.opts, memo.WithMaxEntries[string, int](1))...)
+			m.Set("a", 1)
+
+			// A load which is not cached does not push out other entries.
+			_, _ = m.Get("b", tt.get...)
+
+			if v, err := m.Get("a", memo.GetWithLoader[string, int](nil)); v != 1 || err != nil {
+				t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 1, nil)
+			}
+
+			if got := m.Stats().Evictions; got != 0 {
+				t.Errorf("got: %v, want: %v", got, 0)
 			}
 		})
-	})
+	}
+}
 
-	b.Run("SlowPath", func(b *testing.B) {
-		loader := func(_ string) (string, error) {
-			return "v", nil
+func TestOverweight(t *testing.T) {
+	for name, policy := range map[string]memo.Policy{"LRU": memo.LRU, "TinyLFU": memo.TinyLFU} {
+		t.Run(name, func(t *testing.T) {
+			m := memo.New(
+				memo.WithPolicy[string, string](policy),
+				memo.WithMaxWeight[string, string](100),
+				memo.WithWeigher(func(_ string, v string) int64 { return int64(len(v)) }),
+			)
+
+			for i := 0; i < 10; i++ {
+				m.Set(strconv.Itoa(i), "0123456789")
+			}
+
+			// An entry heavier than the limit is evicted on its own,
+			// whether it is set or grows.
+			m.Set("big", strings.Repeat("x", 1000))
+			m.Set("0", strings.Repeat("x", 1000))
+
+			if n := m.Len(); n != 9 {
+				t.Errorf("got: %v, want: %v", n, 9)
+			}
+
+			if got := m.Stats(); got.Weight != 90 || got.Evictions != 2 {
+				t.Errorf("got: (%v, %v), want: (%v, %v)", got.Weight, got.Evictions, 90, 2)
+			}
+		})
+	}
+}
+
+func TestRefreshInterval(t *testing.T) {
+	fc := memo.NewManualClock()
+	var counter int32
+	loader := func(k string) (int, error) {
+		return int(atomic.AddInt32(&counter, 1)), nil
+	}
+
+	replaced := make(chan int, 1)
+	m := memo.New(
+		memo.WithClock[string, int](fc),
+		memo.WithLoader(loader),
+		memo.WithExpiration[string, int](time.Minute),
+		memo.WithRefreshInterval[string, int](time.Second),
+		memo.WithOnRemove(func(_ string, v int, reason memo.RemovalReason) {
+			if reason == memo.Replaced {
+				replaced <- v
+			}
+		}),
+	)
+
+	if v, _ := m.Get("x"); v != 1 {
+		t.Errorf("got: %v, want: %v", v, 1)
+	}
+
+	// The stale value is returned, and only one reload is triggered.
+	fc.Advance(2 * time.Second)
+	for i := 0; i < 3; i++ {
+		if v, _ := m.Get("x"); v != 1 {
+			t.Errorf("got: %v, want: %v", v, 1)
 		}
-		m := memo.New(
-			memo.WithLoader(loader),
-			memo.WithExpiration[string, string](time.Nanosecond),
-		)
-		b.RunParallel(func(pb *testing.PB) {
-			for pb.Next() {
-				_, _ = m.Get("k")
+	}
+
+	// The stale value is replaced once the reload is done.
+	if v := <-replaced; v != 1 {
+		t.Errorf("got: %v, want: %v", v, 1)
+	}
+
+	if v, _ := m.Get("x", memo.GetWithLoader[string, int](nil)); v != 2 {
+		t.Errorf("got: %v, want: %v", v, 2)
+	}
+
+	if n := atomic.LoadInt32(&counter); n != 2 {
+		t.Errorf("got: %v, want: %v", n, 2)
+	}
+
+	// The reloaded value has a new expiration.
+	fc.Advance(59 * time.Second)
+	if v, err := m.Get("x", memo.GetWithLoader[string, int](nil)); v != 2 || err != nil {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 2, nil)
+	}
+}
+
+func TestRefreshSetDuring(t *testing.T) {
+	fc := memo.NewManualClock()
+	refreshing, release := make(chan struct{}), make(chan struct{})
+	var counter int32
+	loader := func(k string) (int, error) {
+		v := int(atomic.AddInt32(&counter, 1))
+		if v > 1 {
+			refreshing <- struct{}{}
+			<-release
+		}
+		return v, nil
+	}
+
+	var replaced int32
+	m := memo.New(
+		memo.WithClock[string, int](fc),
+		memo.WithLoader(loader),
+		memo.WithRefreshInterval[string, int](time.Second),
+		memo.WithOnRemove(func(_ string, _ int, reason memo.RemovalReason) {
+			if reason == memo.Replaced {
+				atomic.AddInt32(&replaced, 1)
+			}
+		}),
+	)
+
+	m.Get("x")
+	fc.Advance(2 * time.Second)
+	m.Get("x")
+
+	// The value set during the refresh is not overwritten by it.
+	<-refreshing
+	m.Set("x", 100)
+	release <- struct{}{}
+
+	// The first refresh is done once the next one can start, which
+	// signals from its loader.
+	fc.Advance(2 * time.Second)
+	go func() {
+		for atomic.LoadInt32(&counter) < 3 {
+			m.Get("x")
+			runtime.Gosched()
+		}
+	}()
+	<-refreshing
+
+	if v, _ := m.Get("x", memo.GetWithLoader[string, int](nil)); v != 100 {
+		t.Errorf("got: %v, want: %v", v, 100)
+	}
+	if n := atomic.LoadInt32(&replaced); n != 1 {
+		t.Errorf("got: %v, want: %v", n, 1)
+	}
+	close(release)
+}
+
+func TestErrorExpiration(t *testing.T) {
+	errTransient := errors.New("transient")
+
+	tests := []struct {
+		name  string
+		opts  []memo.Option[string, int]
+		after time.Duration
+		want  int32
+	}{
+		{name: "Default", after: 30 * time.Second, want: 1},
+		{name: "Expiration", opts: []memo.Option[string, int]{
+			memo.WithErrorExpiration[string, int](time.Second),
+		}, after: 2 * time.Second, want: 2},
+		{name: "ExpirationZero", opts: []memo.Option[string, int]{
+			memo.WithErrorExpiration[string, int](0),
+		}, after: 2 * time.Minute, want: 1},
+		{name: "PredicateFalse", opts: []memo.Option[string, int]{
+			memo.WithErrorPredicate[string, int](func(err error) bool {
+				return !errors.Is(err, errTransient)
+			}),
+		}, want: 2},
+		{name: "PredicateTrue", opts: []memo.Option[string, int]{
+			memo.WithErrorPredicate[string, int](func(err error) bool {
+				return errors.Is(err, errTransient)
+			}),
+		}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := memo.NewManualClock()
+			var counter int32
+			loader := func(_ string) (int, error) {
+				atomic.AddInt32(&counter, 1)
+				return 0, errTransient
+			}
+
+			opts := append([]memo.Option[string, int]{
+				memo.WithClock[string, int](fc),
+				memo.WithLoader(loader),
+				memo.WithExpiration[string, int](time.Minute),
+			}, tt.opts...)
+			m := memo.New(opts...)
+
+			for i := 0; i < 2; i++ {
+				if _, err := m.Get("x"); !errors.Is(err, errTransient) {
+					t.Errorf("got: %v, want: %v", err, errTransient)
+				}
+				fc.Advance(tt.after)
+			}
+
+			if n := atomic.LoadInt32(&counter); n != tt.want {
+				t.Errorf("got: %v, want: %v", n, tt.want)
 			}
 		})
-	})
+	}
 }
 
-func BenchmarkMemo_Set(b *testing.B) {
-	m := memo.New[string, string]()
-	b.RunParallel(func(pb *testing.PB) {
-		for pb.Next() {
-			m.Set("k", "v")
+func TestLoaderWithTTL(t *testing.T) {
+	fc := memo.NewManualClock()
+	ttls := map[string]time.Duration{"short": time.Second, "forever": 0, "never": memo.DontCache}
+	counters := make(map[string]int)
+	loader := func(k string) (int, time.Duration, error) {
+		counters[k]++
+		return counters[k], ttls[k], nil
+	}
+
+	var removed []string
+	m := memo.New(
+		memo.WithClock[string, int](fc),
+		memo.WithLoaderWithTTL(loader),
+		memo.WithExpiration[string, int](time.Minute),
+		memo.WithOnRemove(func(k string, _ int, _ memo.RemovalReason) {
+			removed = append(removed, k)
+		}),
+	)
+
+	for i := 0; i < 3; i++ {
+		for k := range ttls {
+			_, _ = m.Get(k)
 		}
-	})
+		fc.Advance(time.Second)
+	}
+
+	want := map[string]int{"short": 3, "forever": 1, "never": 3}
+	if !maps.Equal(counters, want) {
+		t.Errorf("got: %v, want: %v", counters, want)
+	}
+
+	if n := m.Len(); n != 1 {
+		t.Errorf("got: %v, want: %v", n, 1)
+	}
+
+	// Values not cached are never notified as removed.
+	if !slices.Equal(removed, []string{"short", "short", "short"}) {
+		t.Errorf("got: %v, want: %v", removed, []string{"short", "short", "short"})
+	}
+
+	// A plain loader per call uses the expiration option again.
+	plain := func(string) (int, error) { return 0, nil }
+	_, _ = m.Get("plain", memo.GetWithLoader(plain), memo.GetWithExpiration[string, int](time.Second))
+	fc.Advance(time.Second)
+
+	if n := m.Len(); n != 1 {
+		t.Errorf("got: %v, want: %v", n, 1)
+	}
 }
 
-func BenchmarkMemo_Del(b *testing.B) {
-	m := memo.New[string, string]()
-	b.RunParallel(func(pb *testing.PB) {
-		for pb.Next() {
-			m.Del("k")
+func TestPeek(t *testing.T) {
+	fc := memo.NewManualClock()
+	var counter int32
+	loader := func(k string) (int, error) {
+		atomic.AddInt32(&counter, 1)
+		return len(k), nil
+	}
+
+	m := memo.New(
+		memo.WithClock[string, int](fc),
+		memo.WithLoader(loader),
+		memo.WithExpiration[string, int](time.Minute),
+		memo.WithMaxEntries[string, int](2),
+	)
+
+	m.Set("a", 1)
+	m.Set("b", 2, memo.SetWithExpiration[string, int](0))
+	fc.Advance(20 * time.Second)
+
+	if v, ok := m.Peek("a"); v != 1 || !ok {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", v, ok, 1, true)
+	}
+
+	if v, ok := m.Peek("x"); v != 0 || ok {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", v, ok, 0, false)
+	}
+
+	tests := []struct {
+		k   string
+		ttl time.Duration
+		ok  bool
+	}{
+		{k: "a", ttl: 40 * time.Second, ok: true},
+		{k: "b", ttl: 0, ok: true},
+		{k: "x", ttl: 0, ok: false},
+	}
+
+	for _, tt := range tests {
+		if ttl, ok := m.TTL(tt.k); ttl != tt.ttl || ok != tt.ok {
+			t.Errorf("%v: got: (%v, %v), want: (%v, %v)", tt.k, ttl, ok, tt.ttl, tt.ok)
+		}
+	}
+
+	// Nothing is loaded, counted or touched.
+	if n := atomic.LoadInt32(&counter); n != 0 {
+		t.Errorf("got: %v, want: %v", n, 0)
+	}
+
+	if got := m.Stats(); got.Hits != 0 || got.Misses != 0 {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", got.Hits, got.Misses, 0, 0)
+	}
+
+	m.Set("c", 3)
+
+	if _, ok := m.Peek("a"); ok {
+		t.Errorf("got: %v, want: %v", ok, false)
+	}
+
+	// Expired values are invisible, but not removed.
+	fc.Advance(time.Minute)
+
+	if _, ok := m.Peek("c"); ok {
+		t.Errorf("got: %v, want: %v", ok, false)
+	}
+
+	if n := m.Stats().Expirations; n != 0 {
+		t.Errorf("got: %v, want: %v", n, 0)
+	}
+
+	t.Run("GetWithMeta", func(t *testing.T) {
+		if v, meta, err := m.GetWithMeta("xyz"); v != 3 || meta.TTL != time.Minute || err != nil {
+			t.Errorf("got: (%v, %v, %v), want: (%v, %v, %v)", v, meta.TTL, err, 3, time.Minute, nil)
+		}
+
+		fc.Advance(10 * time.Second)
+
+		v, meta, err := m.GetWithMeta("xyz")
+		if v != 3 || meta.TTL != 50*time.Second || err != nil {
+			t.Errorf("got: (%v, %v, %v), want: (%v, %v, %v)", v, meta.TTL, err, 3, 50*time.Second, nil)
+		}
+
+		if age := time.Since(meta.LoadedAt); age < 10*time.Second || age > 11*time.Second {
+			t.Errorf("got: %v, want: %v", age, 10*time.Second)
+		}
+
+		if _, _, err := m.GetWithMeta("xyz", memo.GetWithLoader[string, int](nil)); err != nil {
+			t.Errorf("got: %v, want: %v", err, nil)
 		}
 	})
 }
 
-type fakeClock struct {
-	mu       sync.Mutex
-	nanotime int64
-}
+func TestFunc(t *testing.T) {
+	fc := memo.NewManualClock()
+	calls := make(map[string]int)
+
+	t.Run("Func1", func(t *testing.T) {
+		f := memo.Func1(func(a string) (int, error) {
+			calls["Func1"]++
+			return len(a), nil
+		}, memo.WithClock[string, int](fc), memo.WithExpiration[string, int](time.Minute))
+
+		for _, a := range []string{"x", "yy", "x"} {
+			if v, err := f(a); v != len(a) || err != nil {
+				t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, len(a), nil)
+			}
+		}
+
+		fc.Advance(time.Minute)
+		_, _ = f("x")
+	})
+
+	t.Run("Func3", func(t *testing.T) {
+		f := memo.Func3(func(a string, b, c int) (string, error) {
+			calls["Func3"]++
+			return strings.Repeat(a, b+c), nil
+		})
+
+		for i := 0; i < 2; i++ {
+			if v, err := f("x", 1, 2); v != "xxx" || err != nil {
+				t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, "xxx", nil)
+			}
+		}
+	})
+
+	t.Run("FuncBy", func(t *testing.T) {
+		f := memo.FuncBy(func(a []string) (int, error) {
+			calls["FuncBy"]++
+			return len(a), nil
+		}, func(a []string) string {
+			return strings.Join(a, ",")
+		})
+
+		for _, a := range [][]string{{"x", "y"}, {"x"}, {"x", "y"}} {
+			if v, err := f(a); v != len(a) || err != nil {
+				t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, len(a), nil)
+			}
+		}
+	})
 
-func newFakeClock() *fakeClock {
-	return &fakeClock{}
+	want := map[string]int{"Func1": 3, "Func3": 1, "FuncBy": 2}
+	if !maps.Equal(calls, want) {
+		t.Errorf("got: %v, want: %v", calls, want)
+	}
 }
 
-func (fc *fakeClock) Now() int64 {
-	fc.mu.Lock()
-	nt := fc.nanotime
-	fc.mu.Unlock()
-	return nt
+func TestGetContext(t *testing.T) {
+	t.Run("Cancel", func(t *testing.T) {
+		release := make(chan struct{})
+		var counter int32
+		loader := func(ctx context.Context, k string) (int, error) {
+			atomic.AddInt32(&counter, 1)
+			<-release
+			return len(k), ctx.Err()
+		}
+
+		m := memo.New(memo.WithLoaderContext(loader))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		result := make(chan error)
+		go func() {
+			_, err := m.GetContext(ctx, "x")
+			result <- err
+		}()
+		cancel()
+
+		if err := <-result; !errors.Is(err, context.Canceled) {
+			t.Errorf("got: %v, want: %v", err, context.Canceled)
+		}
+
+		// The load continues for other callers.
+		go close(release)
+		if v, err := m.Get("x"); v != 1 || err != nil {
+			t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 1, nil)
+		}
+
+		if n := atomic.LoadInt32(&counter); n != 1 {
+			t.Errorf("got: %v, want: %v", n, 1)
+		}
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		deadline := time.Now().Add(time.Hour)
+		var counter int32
+		loader := func(ctx context.Context, _ string) (int, error) {
+			atomic.AddInt32(&counter, 1)
+			if d, ok := ctx.Deadline(); !ok || !d.Equal(deadline) {
+				t.Errorf("got: (%v, %v), want: (%v, %v)", d, ok, deadline, true)
+			}
+			return 0, context.DeadlineExceeded
+		}
+
+		m := memo.New(memo.WithLoaderContext(loader))
+
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+
+		// Context errors are never cached.
+		for i := 0; i < 2; i++ {
+			if _, err := m.GetContext(ctx, "x"); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("got: %v, want: %v", err, context.DeadlineExceeded)
+			}
+		}
+
+		if n := atomic.LoadInt32(&counter); n != 2 {
+			t.Errorf("got: %v, want: %v", n, 2)
+		}
+	})
 }
 
-func (fc *fakeClock) advance(d time.Duration) {
-	fc.mu.Lock()
-	fc.nanotime += int64(d)
-	fc.mu.Unlock()
+func TestLoaderPanic(t *testing.T) {
+	errBoom := errors.New("boom")
+	started, release := make(chan struct{}), make(chan struct{})
+	var counter int32
+	loader := func(k string) (int, error) {
+		if atomic.AddInt32(&counter, 1) == 1 {
+			close(started)
+			<-release
+			panic(errBoom)
+		}
+		return len(k), nil
+	}
+
+	m := memo.New(memo.WithLoader(loader))
+
+	// Every caller waiting on the load gets the panic.
+	results := make(chan error, 2)
+	go func() {
+		_, err := m.Get("x")
+		results <- err
+	}()
+	<-started
+	go func() {
+		_, err := m.GetContext(context.Background(), "x")
+		results <- err
+	}()
+	for m.Stats().Hits == 0 {
+		runtime.Gosched()
+	}
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		var pe *memo.PanicError
+		err := <-results
+		if !errors.As(err, &pe) || pe.Value != errBoom || len(pe.Stack) == 0 {
+			t.Errorf("got: %v, want: %T", err, pe)
+		}
+		if !errors.Is(err, errBoom) {
+			t.Errorf("got: %v, want: %v", err, errBoom)
+		}
+	}
+
+	// The key is not poisoned.
+	if v, err := m.Get("x"); v != 1 || err != nil {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 1, nil)
+	}
+
+	if n := atomic.LoadInt32(&counter); n != 2 {
+		t.Errorf("got: %v, want: %v", n, 2)
+	}
+}
+
+func TestLoaderGoexit(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var counter int32
+	loader := func(k string) (int, error) {
+		if atomic.AddInt32(&counter, 1) == 1 {
+			close(started)
+			<-release
+			runtime.Goexit()
+		}
+		return len(k), nil
+	}
+
+	m := memo.New(memo.WithLoader(loader))
+
+	// The callers waiting on the load are woken up.
+	go func() { _, _ = m.Get("x") }()
+	<-started
+	result := make(chan error)
+	go func() {
+		_, err := m.Get("x")
+		result <- err
+	}()
+	for m.Stats().Hits == 0 {
+		runtime.Gosched()
+	}
+	close(release)
+
+	if err := <-result; !errors.Is(err, memo.ErrGoexit) {
+		t.Errorf("got: %v, want: %v", err, memo.ErrGoexit)
+	}
+
+	// The key is not poisoned.
+	if v, err := m.Get("x"); v != 1 || err != nil {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 1, nil)
+	}
+
+	t.Run("Bulk", func(t *testing.T) {
+		var counter int32
+		m := memo.New(memo.WithBulkLoader(func(keys []string) (map[string]int, error) {
+			if atomic.AddInt32(&counter, 1) == 1 {
+				runtime.Goexit()
+			}
+			return map[string]int{"x": 1, "y": 2}, nil
+		}))
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = m.GetMany([]string{"x", "y"})
+		}()
+		<-done
+
+		if values, errs := m.GetMany([]string{"x", "y"}); len(values) != 2 || len(errs) != 0 {
+			t.Errorf("got: (%v, %v), want: (%v, %v)", values, errs, 2, 0)
+		}
+	})
+}
+
+func TestGetMany(t *testing.T) {
+	var calls [][]string
+	bulkLoader := func(keys []string) (map[string]int, error) {
+		calls = append(calls, keys)
+		values := make(map[string]int)
+		for _, k := range keys {
+			if k != "missing" {
+				values[k] = len(k)
+			}
+		}
+		return values, nil
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	loader := func(k string) (int, error) {
+		close(started)
+		<-release
+		return 10 * len(k), nil
+	}
+
+	m := memo.New(memo.WithBulkLoader(bulkLoader))
+	m.Set("a", 100)
+
+	// The key "bb" is being loaded by another caller.
+	loaded := make(chan struct{})
+	go func() {
+		defer close(loaded)
+		_, _ = m.Get("bb", memo.GetWithLoader(loader))
+	}()
+	<-started
+	time.AfterFunc(10*time.Millisecond, func() { close(release) })
+
+	values, errs := m.GetMany([]string{"a", "bb", "ccc", "missing", "ccc"})
+	<-loaded
+
+	wantValues := map[string]int{"a": 100, "bb": 20, "ccc": 3}
+	if fmt.Sprint(values) != fmt.Sprint(wantValues) {
+		t.Errorf("got: %v, want: %v", values, wantValues)
+	}
+
+	if len(errs) != 1 || !errors.Is(errs["missing"], memo.ErrNotFound) {
+		t.Errorf("got: %v, want: %v", errs, map[string]error{"missing": memo.ErrNotFound})
+	}
+
+	if fmt.Sprint(calls) != "[[ccc missing]]" {
+		t.Errorf("got: %v, want: %v", calls, "[[ccc missing]]")
+	}
+
+	// All keys get the error of the bulk loader.
+	errBulk := errors.New("bulk")
+	_, errs = m.GetMany([]string{"a", "d", "e"}, memo.GetWithBulkLoader(func([]string) (map[string]int, error) {
+		return nil, errBulk
+	}))
+
+	if len(errs) != 2 || !errors.Is(errs["d"], errBulk) || !errors.Is(errs["e"], errBulk) {
+		t.Errorf("got: %v, want: %v", errs, map[string]error{"d": errBulk, "e": errBulk})
+	}
+}
+
+func TestStats(t *testing.T) {
+	fc := memo.NewManualClock()
+	loader := func(k string) (int, error) {
+		fc.Advance(time.Second)
+		return length(k)
+	}
+
+	m := memo.New(
+		memo.WithClock[string, int](fc),
+		memo.WithLoader(loader),
+		memo.WithMaxEntries[string, int](3),
+	)
+
+	_, _ = m.Get("x")
+	_, _ = m.Get("x")
+	_, _ = m.Get("error")
+	m.Set("y", 1, memo.SetWithExpiration[string, int](time.Minute))
+	fc.Advance(time.Minute)
+	_, _ = m.Get("y", memo.GetWithLoader[string, int](nil))
+	m.Set("a", 1)
+	m.Set("b", 1)
+
+	want := memo.Stats{
+		Hits:          1,
+		Misses:        3,
+		LoadSuccesses: 1,
+		LoadFailures:  1,
+		TotalLoadTime: 2 * time.Second,
+		Expirations:   1,
+		Evictions:     1,
+		Size:          3,
+		Weight:        3,
+	}
+
+	got := m.Stats()
+	if got != want {
+		t.Errorf("got: %+v, want: %+v", got, want)
+	}
+
+	if ratio := got.HitRatio(); ratio != 0.25 {
+		t.Errorf("got: %v, want: %v", ratio, 0.25)
+	}
+
+	var vars map[string]any
+	if err := json.Unmarshal([]byte(m.Var().String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+
+	if vars["Misses"] != float64(3) {
+		t.Errorf("got: %v, want: %v", vars["Misses"], 3)
+	}
+}
+
+func TestOnRemove(t *testing.T) {
+	fc := memo.NewManualClock()
+	var m *memo.Memo[string, int]
+	var removals []string
+	onRemove := func(k string, v int, reason memo.RemovalReason) {
+		// The memo is not locked when notifying.
+		_, _ = m.Get(k)
+		removals = append(removals, fmt.Sprintf("%v=%v %v", k, v, reason))
+	}
+
+	m = memo.New(
+		memo.WithClock[string, int](fc),
+		memo.WithMaxEntries[string, int](2),
+		memo.WithOnRemove(onRemove),
+	)
+
+	m.Set("a", 1)
+	m.Set("a", 2)
+	m.Set("b", 1, memo.SetWithExpiration[string, int](time.Minute))
+	fc.Advance(time.Minute)
+	_, _ = m.Get("b")
+	m.Set("c", 1)
+	m.Set("d", 1)
+	m.Del("c")
+	_, _ = m.Get("e", memo.GetWithLoader(length))
+	m.Del("e")
+
+	// Clear still tells the expired entries apart.
+	m.Set("f", 1, memo.SetWithExpiration[string, int](time.Minute))
+	fc.Advance(time.Minute)
+	m.Clear()
+
+	want := "[a=1 replaced b=1 expired a=2 evicted c=1 deleted e=1 deleted f=1 expired d=1 deleted]"
+	if got := fmt.Sprint(removals); got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+
+	if got := m.Stats().Expirations; got != 2 {
+		t.Errorf("got: %v, want: %v", got, 2)
+	}
+}
+
+func TestIteration(t *testing.T) {
+	fc := memo.NewManualClock()
+	m := memo.New(memo.WithClock[string, int](fc), memo.WithShards[string, int](4))
+
+	m.Set("a", 1)
+	m.Set("b", 2)
+	m.Set("c", 3, memo.SetWithExpiration[string, int](time.Minute))
+	_, _ = m.Get("error", memo.GetWithLoader(length))
+
+	started, release := make(chan struct{}), make(chan struct{})
+	loaded := make(chan struct{})
+	go func() {
+		defer close(loaded)
+		_, _ = m.Get("loading", memo.GetWithLoader(func(k string) (int, error) {
+			close(started)
+			<-release
+			return length(k)
+		}))
+	}()
+	<-started
+	defer func() { close(release); <-loaded }()
+
+	fc.Advance(time.Minute)
+
+	// Len counts the same pairs as All.
+	if n := m.Len(); n != 2 {
+		t.Errorf("got: %v, want: %v", n, 2)
+	}
+
+	all := make(map[string]int)
+	for k, v := range m.All() {
+		// It is safe to modify the memo during iteration.
+		m.Set(k, 10*v)
+		all[k] = v
+	}
+
+	if want := map[string]int{"a": 1, "b": 2}; fmt.Sprint(all) != fmt.Sprint(want) {
+		t.Errorf("got: %v, want: %v", all, want)
+	}
+
+	keys := slices.Sorted(m.Keys())
+	if want := []string{"a", "b"}; !slices.Equal(keys, want) {
+		t.Errorf("got: %v, want: %v", keys, want)
+	}
+
+	for range m.Keys() {
+		break
+	}
+
+	m.Clear()
+
+	if n := m.Len(); n != 0 {
+		t.Errorf("got: %v, want: %v", n, 0)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	fc := memo.NewManualClock()
+	m := memo.New(memo.WithClock[string, int](fc), memo.WithShards[string, int](4))
+	m.Set("a", 1)
+	m.Set("b", 2, memo.SetWithExpiration[string, int](2*time.Minute))
+	m.Set("c", 3, memo.SetWithExpiration[string, int](time.Minute))
+	_, _ = m.Get("error", memo.GetWithLoader(length))
+	fc.Advance(time.Minute)
+
+	var buf bytes.Buffer
+	if err := m.Snapshot(&buf, memo.JSONCodec[string, int]{}); err != nil {
+		t.Fatal(err)
+	}
+
+	fc = memo.NewManualClock()
+	m = memo.New(memo.WithClock[string, int](fc), memo.WithExpiration[string, int](time.Second))
+	if err := m.Restore(bytes.NewReader(buf.Bytes()), memo.JSONCodec[string, int]{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The remaining lifetime of "b" is about one minute.
+	fc.Advance(59 * time.Second)
+	if got := maps.Collect(m.All()); fmt.Sprint(got) != "map[a:1 b:2]" {
+		t.Errorf("got: %v, want: %v", got, "map[a:1 b:2]")
+	}
+
+	fc.Advance(time.Second)
+	if got := maps.Collect(m.All()); fmt.Sprint(got) != "map[a:1]" {
+		t.Errorf("got: %v, want: %v", got, "map[a:1]")
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "Empty", data: nil},
+		{name: "Magic", data: []byte("mem0\x01")},
+		{name: "Truncated", data: buf.Bytes()[:buf.Len()-1]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.Restore(bytes.NewReader(tt.data), memo.JSONCodec[string, int]{})
+			if !errors.Is(err, memo.ErrInvalidSnapshot) {
+				t.Errorf("got: %v, want: %v", err, memo.ErrInvalidSnapshot)
+			}
+		})
+	}
+
+	t.Run("Store", func(t *testing.T) {
+		store := memo.NewMapStore[string, int](fc)
+		var removed []string
+		m := memo.New(
+			memo.WithClock[string, int](fc),
+			memo.WithStore[string, int](store),
+			memo.WithOnRemove(func(k string, _ int, _ memo.RemovalReason) {
+				removed = append(removed, k)
+			}),
+		)
+		m.Set("a", 10)
+
+		if err := m.Restore(bytes.NewReader(buf.Bytes()), memo.JSONCodec[string, int]{}); err != nil {
+			t.Fatal(err)
+		}
+
+		// The present key is newer, and restored values are not written through.
+		if got := maps.Collect(m.All()); fmt.Sprint(got) != "map[a:10 b:2]" {
+			t.Errorf("got: %v, want: %v", got, "map[a:10 b:2]")
+		}
+
+		if _, _, err := store.Get(context.Background(), "b"); !errors.Is(err, memo.ErrNotFound) {
+			t.Errorf("got: %v, want: %v", err, memo.ErrNotFound)
+		}
+
+		if len(removed) != 0 {
+			t.Errorf("got: %v, want: %v", removed, []string{})
+		}
+	})
+}
+
+func TestSlidingExpiration(t *testing.T) {
+	tests := []struct {
+		name    string
+		sliding bool
+		opts    []memo.GetOption[string, int]
+		want    []error
+	}{
+		{name: "Memo", sliding: true, want: []error{nil, nil, memo.ErrNotFound}},
+		{name: "Get", opts: []memo.GetOption[string, int]{
+			memo.GetWithSlidingExpiration[string, int](true),
+		}, want: []error{nil, nil, memo.ErrNotFound}},
+		{name: "GetDisabled", sliding: true, opts: []memo.GetOption[string, int]{
+			memo.GetWithSlidingExpiration[string, int](false),
+		}, want: []error{nil, memo.ErrNotFound, memo.ErrNotFound}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := memo.NewManualClock()
+			m := memo.New(
+				memo.WithClock[string, int](fc),
+				memo.WithExpiration[string, int](time.Minute),
+				memo.WithSlidingExpiration[string, int](tt.sliding),
+			)
+			m.Set("x", 1)
+
+			for i, d := range []time.Duration{50 * time.Second, 50 * time.Second, 61 * time.Second} {
+				fc.Advance(d)
+				if _, err := m.Get("x", tt.opts...); !errors.Is(err, tt.want[i]) {
+					t.Errorf("%v: got: %v, want: %v", i, err, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCompute(t *testing.T) {
+	fc := memo.NewManualClock()
+	m := memo.New(memo.WithClock[string, int](fc))
+
+	incr := func(old int, _ bool) (int, bool) { return old + 1, true }
+
+	if v, ok := m.Compute("a", incr, memo.SetWithExpiration[string, int](time.Minute)); v != 1 || !ok {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", v, ok, 1, true)
+	}
+
+	if v, ok := m.Compute("a", incr); v != 2 || !ok {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", v, ok, 2, true)
+	}
+
+	if v, ok := m.Compute("a", func(int, bool) (int, bool) { return 0, false }); v != 0 || ok {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", v, ok, 0, false)
+	}
+
+	if v, loaded := m.GetOrSet("b", 1, memo.SetWithExpiration[string, int](time.Minute)); v != 1 || loaded {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", v, loaded, 1, false)
+	}
+
+	if v, loaded := m.GetOrSet("b", 2); v != 1 || !loaded {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", v, loaded, 1, true)
+	}
+
+	tests := []struct {
+		name string
+		got  bool
+		want bool
+	}{
+		{name: "SwapMismatch", got: m.CompareAndSwap("b", 2, 3), want: false},
+		{name: "SwapMissing", got: m.CompareAndSwap("c", 0, 3), want: false},
+		{name: "Swap", got: m.CompareAndSwap("b", 1, 3), want: true},
+		{name: "DeleteMismatch", got: m.CompareAndDelete("b", 1), want: false},
+		{name: "Delete", got: m.CompareAndDelete("b", 3), want: true},
+		{name: "DeleteMissing", got: m.CompareAndDelete("b", 3), want: false},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%v: got: %v, want: %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// Expired values are absent.
+	m.Set("d", 1, memo.SetWithExpiration[string, int](time.Minute))
+	fc.Advance(time.Minute)
+
+	if v, loaded := m.GetOrSet("d", 2); v != 2 || loaded {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", v, loaded, 2, false)
+	}
+
+	t.Run("Concurrent", func(t *testing.T) {
+		m := memo.New(memo.WithShards[string, int](4))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m.Compute("x", incr)
+			}()
+		}
+		wg.Wait()
+
+		if v, err := m.Get("x"); v != 100 || err != nil {
+			t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 100, nil)
+		}
+	})
+
+	t.Run("Loading", func(t *testing.T) {
+		started, release := make(chan struct{}), make(chan struct{})
+		loader := func(string) (int, error) {
+			close(started)
+			<-release
+			return 1, nil
+		}
+
+		m := memo.New(memo.WithLoader(loader))
+
+		go func() { _, _ = m.Get("x") }()
+		<-started
+		time.AfterFunc(10*time.Millisecond, func() { close(release) })
+
+		// The swap waits for the load instead of missing it.
+		if !m.CompareAndSwap("x", 1, 2) {
+			t.Errorf("got: %v, want: %v", false, true)
+		}
+
+		if v, err := m.Get("x"); v != 2 || err != nil {
+			t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 2, nil)
+		}
+	})
+}
+
+func TestInvalidation(t *testing.T) {
+	for _, shards := range []int{1, 8} {
+		t.Run(fmt.Sprint(shards), func(t *testing.T) {
+			m := memo.New(memo.WithShards[string, int](shards))
+
+			m.Set("a1", 1, memo.SetWithTags[string, int]("a"))
+			m.Set("a2", 2, memo.SetWithTags[string, int]("a", "even"))
+			m.Set("b1", 3, memo.SetWithTags[string, int]("b"))
+			m.Set("b2", 4, memo.SetWithTags[string, int]("b", "even"))
+			m.Set("c", 5)
+
+			// The tags are replaced along with the value.
+			m.Set("b2", 6, memo.SetWithTags[string, int]("b"))
+
+			if n := m.InvalidateTag("even"); n != 1 {
+				t.Errorf("got: %v, want: %v", n, 1)
+			}
+
+			if n := m.InvalidateTag("even"); n != 0 {
+				t.Errorf("got: %v, want: %v", n, 0)
+			}
+
+			if n := m.DelFunc(func(k string, _ int) bool { return strings.HasPrefix(k, "a") }); n != 1 {
+				t.Errorf("got: %v, want: %v", n, 1)
+			}
+
+			if n := m.InvalidateTag("b"); n != 2 {
+				t.Errorf("got: %v, want: %v", n, 2)
+			}
+
+			if got, want := slices.Sorted(m.Keys()), []string{"c"}; !slices.Equal(got, want) {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+		})
+	}
+}
+
+// sleepRecorder is a Sleeper which records the durations
+// to sleep, and returns at once.
+type sleepRecorder struct {
+	memo.RealClock
+	slept []time.Duration
+}
+
+func (sr *sleepRecorder) Sleep(_ context.Context, d time.Duration) error {
+	sr.slept = append(sr.slept, d)
+	return nil
+}
+
+// blockingStore is a Store whose first Set blocks until release is closed.
+type blockingStore[K comparable, V any] struct {
+	*memo.MapStore[K, V]
+	started chan struct{}
+	release chan struct{}
+	sets    int32
+}
+
+func (bs *blockingStore[K, V]) Set(ctx context.Context, k K, v V, ttl time.Duration) error {
+	if atomic.AddInt32(&bs.sets, 1) == 1 {
+		close(bs.started)
+		<-bs.release
+	}
+	return bs.MapStore.Set(ctx, k, v, ttl)
+}
+
+type failingStore[K comparable, V any] struct {
+	err error
+}
+
+func (fs failingStore[K, V]) Get(context.Context, K) (V, time.Duration, error) {
+	var zero V
+	return zero, 0, fs.err
+}
+
+func (fs failingStore[K, V]) Set(context.Context, K, V, time.Duration) error {
+	return fs.err
+}
+
+func (fs failingStore[K, V]) Del(context.Context, K) error {
+	return fs.err
+}
+
+func TestStore(t *testing.T) {
+	fc := memo.NewManualClock()
+	store := memo.NewMapStore[string, int](fc)
+	_ = store.Set(context.Background(), "a", 1, 30*time.Second)
+
+	var counter int32
+	loader := func(k string) (int, error) {
+		atomic.AddInt32(&counter, 1)
+		return 10 * len(k), nil
+	}
+
+	newMemo := func() *memo.Memo[string, int] {
+		return memo.New(
+			memo.WithClock[string, int](fc),
+			memo.WithStore[string, int](store),
+			memo.WithLoader(loader),
+			memo.WithExpiration[string, int](time.Minute),
+		)
+	}
+	m1, m2 := newMemo(), newMemo()
+
+	// The value is got from the store, and expires along with it.
+	if v, err := m1.Get("a"); v != 1 || err != nil {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 1, nil)
+	}
+
+	fc.Advance(30 * time.Second)
+
+	if v, err := m1.Get("a"); v != 10 || err != nil {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 10, nil)
+	}
+
+	// The loaded value is written to the store.
+	if v, ttl, err := store.Get(context.Background(), "a"); v != 10 || ttl != time.Minute || err != nil {
+		t.Errorf("got: (%v, %v, %v), want: (%v, %v, %v)", v, ttl, err, 10, time.Minute, nil)
+	}
+
+	if v, err := m2.Get("a"); v != 10 || err != nil {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 10, nil)
+	}
+
+	if n := atomic.LoadInt32(&counter); n != 1 {
+		t.Errorf("got: %v, want: %v", n, 1)
+	}
+
+	// Set and Del are written through.
+	m1.Set("b", 2)
+
+	if v, err := m2.Get("b"); v != 2 || err != nil {
+		t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 2, nil)
+	}
+
+	m1.Del("b")
+
+	if _, _, err := store.Get(context.Background(), "b"); !errors.Is(err, memo.ErrNotFound) {
+		t.Errorf("got: %v, want: %v", err, memo.ErrNotFound)
+	}
+
+	t.Run("NoLoader", func(t *testing.T) {
+		m := memo.New(memo.WithStore[string, int](store))
+
+		if v, err := m.Get("a"); v != 10 || err != nil {
+			t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 10, nil)
+		}
+
+		if _, err := m.Get("x"); !errors.Is(err, memo.ErrNotFound) {
+			t.Errorf("got: %v, want: %v", err, memo.ErrNotFound)
+		}
+
+		// The miss is not cached.
+		_ = store.Set(context.Background(), "x", 1, 0)
+
+		if v, err := m.Get("x"); v != 1 || err != nil {
+			t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 1, nil)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		errStore := errors.New("store")
+		var failures []string
+		m := memo.New(
+			memo.WithStore[string, int](failingStore[string, int]{err: errStore}),
+			memo.WithOnStoreError[string, int](func(k string, err error) {
+				if !errors.Is(err, errStore) {
+					t.Errorf("got: %v, want: %v", err, errStore)
+				}
+				failures = append(failures, k)
+			}),
+			memo.WithLoader(loader),
+		)
+
+		// The loader is a fallback of the store.
+		if v, err := m.Get("x"); v != 10 || err != nil {
+			t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 10, nil)
+		}
+
+		m.Set("y", 1)
+		m.Del("z")
+
+		if want := []string{"x", "x", "y", "z"}; !slices.Equal(failures, want) {
+			t.Errorf("got: %v, want: %v", failures, want)
+		}
+	})
+
+	t.Run("Order", func(t *testing.T) {
+		store := &blockingStore[string, int]{
+			MapStore: memo.NewMapStore[string, int](fc),
+			started:  make(chan struct{}),
+			release:  make(chan struct{}),
+		}
+		m := memo.New(memo.WithStore[string, int](store))
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			m.Set("d", 1)
+		}()
+
+		// The later write is not overtaken by the blocked one.
+		<-store.started
+		m.Set("d", 2)
+		close(store.release)
+		<-done
+
+		if v, _, err := store.Get(context.Background(), "d"); v != 2 || err != nil {
+			t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 2, nil)
+		}
+	})
+
+	t.Run("SetDuringLoad", func(t *testing.T) {
+		loading, release := make(chan struct{}), make(chan struct{})
+		m := memo.New(
+			memo.WithStore[string, int](store),
+			memo.WithLoader(func(k string) (int, error) {
+				close(loading)
+				<-release
+				return 10, nil
+			}),
+		)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			m.Get("c")
+		}()
+
+		// The value being loaded is out of date, so it is not written.
+		<-loading
+		m.Set("c", 3)
+		close(release)
+		<-done
+
+		if v, _, err := store.Get(context.Background(), "c"); v != 3 || err != nil {
+			t.Errorf("got: (%v, %v), want: (%v, %v)", v, err, 3, nil)
+		}
+	})
+}
+
+func TestLoadRetry(t *testing.T) {
+	errTransient := errors.New("transient")
+	errPermanent := errors.New("permanent")
+
+	t.Run("Backoff", func(t *testing.T) {
+		fc := memo.NewManualClock()
+		var attempts []int64
+		loader := func(k string) (int, error) {
+			attempts = append(attempts, fc.Now())
+			if len(attempts) < 3 {
+				return 0, errTransient
+			}
+			return len(k), nil
+		}
+
+		m := memo.New(
+			memo.WithClock[string, int](fc),
+			memo.WithLoader(loader),
+			memo.WithLoadRetry[string, int](memo.RetryPolicy{
+				MaxAttempts:    3,
+				InitialBackoff: time.Second,
+			}),
+		)
+
+		results := make(chan int, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				v, _ := m.Get("x")
+				results <- v
+			}()
+		}
+
+		fc.BlockUntil(1)
+		fc.Advance(time.Second)
+		fc.BlockUntil(1)
+		fc.Advance(2 * time.Second)
+
+		for i := 0; i < 2; i++ {
+			if v := <-results; v != 1 {
+				t.Errorf("got: %v, want: %v", v, 1)
+			}
+		}
+
+		want := []int64{0, int64(time.Second), int64(3 * time.Second)}
+		if !slices.Equal(attempts, want) {
+			t.Errorf("got: %v, want: %v", attempts, want)
+		}
+	})
+
+	t.Run("DefaultMaxBackoff", func(t *testing.T) {
+		clock := &sleepRecorder{}
+		m := memo.New(
+			memo.WithClock[string, int](clock),
+			memo.WithLoader(func(string) (int, error) { return 0, errTransient }),
+			memo.WithLoadRetry[string, int](memo.RetryPolicy{
+				MaxAttempts:    100,
+				InitialBackoff: time.Second,
+			}),
+		)
+
+		_, _ = m.Get("x")
+
+		// The backoff grows up to 30 seconds if the maximum is not set.
+		if !slices.IsSorted(clock.slept) || clock.slept[0] != time.Second {
+			t.Errorf("got: %v, want: %v", clock.slept, "sorted from 1s")
+		}
+
+		if d := clock.slept[len(clock.slept)-1]; d != 30*time.Second {
+			t.Errorf("got: %v, want: %v", d, 30*time.Second)
+		}
+	})
+
+	tests := []struct {
+		name string
+		err  error
+		want int32
+	}{
+		{name: "Exhausted", err: errTransient, want: 3},
+		{name: "NotRetryable", err: errPermanent, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var counter int32
+			loader := func(string) (int, error) {
+				atomic.AddInt32(&counter, 1)
+				return 0, tt.err
+			}
+
+			m := memo.New(
+				memo.WithLoader(loader),
+				memo.WithLoadRetry[string, int](memo.RetryPolicy{
+					MaxAttempts: 3,
+					Jitter:      1,
+					Retryable: func(err error) bool {
+						return errors.Is(err, errTransient)
+					},
+				}),
+			)
+
+			if _, err := m.Get("x"); !errors.Is(err, tt.err) {
+				t.Errorf("got: %v, want: %v", err, tt.err)
+			}
+
+			if n := atomic.LoadInt32(&counter); n != tt.want {
+				t.Errorf("got: %v, want: %v", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimingWheel(t *testing.T) {
+	fc := memo.NewManualClock()
+	r := rand.New(rand.NewSource(1))
+	m := memo.New(
+		memo.WithClock[int, int](fc),
+		memo.WithExpirationIndex[int, int](memo.TimingWheel),
+	)
+
+	// Lifetimes range from a microsecond to about 30 days.
+	expireAt := make([]int64, 10000)
+	for k := range expireAt {
+		d := time.Duration(math.Exp(r.Float64() * math.Log(float64(30*24*time.Hour/time.Microsecond))))
+		m.Set(k, k, memo.SetWithExpiration[int, int](d*time.Microsecond))
+		expireAt[k] = int64(d * time.Microsecond)
+	}
+
+	const lag = int64(1 << 20)
+
+	for now := int64(0); now < int64(31*24*time.Hour); {
+		step := time.Duration(math.Exp(r.Float64() * math.Log(float64(time.Hour))))
+		fc.Advance(step)
+		now += int64(step)
+		_ = m.Len()
+
+		var atLeast, atMost uint64
+		for _, at := range expireAt {
+			if at <= now-lag {
+				atLeast++
+			}
+			if at <= now {
+				atMost++
+			}
+		}
+
+		if n := m.Stats().Expirations; n < atLeast || n > atMost {
+			t.Fatalf("%v: got: %v, want: [%v, %v]", time.Duration(now), n, atLeast, atMost)
+		}
+	}
+
+	if n := m.Len(); n != 0 {
+		t.Errorf("got: %v, want: %v", n, 0)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	weigher := func(int, int) int64 { return 50 }
+
+	tests := []struct {
+		name    string
+		policy  memo.Policy
+		opts    []memo.Option[int, int]
+		hot     int
+		atLeast int
+		atMost  int
+	}{
+		{name: "LRU", policy: memo.LRU, opts: []memo.Option[int, int]{
+			memo.WithMaxEntries[int, int](100),
+		}, hot: 80, atLeast: 0, atMost: 0},
+		{name: "TinyLFU", policy: memo.TinyLFU, opts: []memo.Option[int, int]{
+			memo.WithMaxEntries[int, int](100),
+		}, hot: 80, atLeast: 70, atMost: 80},
+		{name: "TinyLFUSmallEntries", policy: memo.TinyLFU, opts: []memo.Option[int, int]{
+			memo.WithMaxEntries[int, int](10),
+		}, hot: 8, atLeast: 6, atMost: 8},
+		{name: "TinyLFUSmallWeight", policy: memo.TinyLFU, opts: []memo.Option[int, int]{
+			memo.WithMaxWeight[int, int](500),
+			memo.WithWeigher(weigher),
+		}, hot: 8, atLeast: 6, atMost: 8},
+		{name: "TinyLFUSmallShards", policy: memo.TinyLFU, opts: []memo.Option[int, int]{
+			memo.WithMaxEntries[int, int](1000),
+			memo.WithShards[int, int](64),
+		}, hot: 400, atLeast: 300, atMost: 400},
+	}
+
+	loader := func(k int) (int, error) {
+		return k, nil
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]memo.Option[int, int]{
+				memo.WithLoader(loader),
+				memo.WithPolicy[int, int](tt.policy),
+			}, tt.opts...)
+			m := memo.New(opts...)
+
+			// Make the hot keys frequently used.
+			for i := 0; i < 10; i++ {
+				for k := 0; k < tt.hot; k++ {
+					_, _ = m.Get(k)
+				}
+			}
+
+			// Scan a lot of one-hit keys.
+			for k := 100000; k < 110000; k++ {
+				_, _ = m.Get(k)
+			}
+
+			hits := 0
+			for k := 0; k < tt.hot; k++ {
+				if _, err := m.Get(k, memo.GetWithLoader[int, int](nil)); err == nil {
+					hits++
+				}
+			}
+
+			if hits < tt.atLeast || hits > tt.atMost {
+				t.Errorf("got: %v, want: [%v, %v]", hits, tt.atLeast, tt.atMost)
+			}
+		})
+	}
+}
+
+func BenchmarkMemo_Get(b *testing.B) {
+	b.Run("FastPath", func(b *testing.B) {
+		m := memo.New[string, string]()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_, _ = m.Get("k")
+			}
+		})
+	})
+
+	b.Run("SlowPath", func(b *testing.B) {
+		loader := func(_ string) (string, error) {
+			return "v", nil
+		}
+		m := memo.New(
+			memo.WithLoader(loader),
+			memo.WithExpiration[string, string](time.Nanosecond),
+		)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_, _ = m.Get("k")
+			}
+		})
+	})
+}
+
+func BenchmarkMemo_Shards(b *testing.B) {
+	const keys = 1 << 16
+
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("Shards%v", shards), func(b *testing.B) {
+			m := memo.New(memo.WithShards[int, int](shards))
+			for k := 0; k < keys; k++ {
+				m.Set(k, k)
+			}
+
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					k := r.Intn(keys)
+					if k%8 == 0 {
+						m.Set(k, k)
+					} else {
+						_, _ = m.Get(k)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkMemo_ExpirationIndex(b *testing.B) {
+	const keys = 1 << 20
+
+	for _, index := range expirationIndexes {
+		b.Run(index.name, func(b *testing.B) {
+			r := rand.New(rand.NewSource(1))
+			expiration := func() memo.SetOption[int, int] {
+				return memo.SetWithExpiration[int, int](time.Second + time.Duration(r.Int63n(int64(time.Hour))))
+			}
+
+			m := memo.New(memo.WithExpirationIndex[int, int](index.index))
+			for k := 0; k < keys; k++ {
+				m.Set(k, k, expiration())
+			}
+
+			b.ResetTimer()
+
+			// Churn: every Set reschedules a key to a random expiration.
+			for i := 0; i < b.N; i++ {
+				k := r.Intn(keys)
+				m.Set(k, k, expiration())
+			}
+		})
+	}
+}
+
+func BenchmarkMemo_Set(b *testing.B) {
+	m := memo.New[string, string]()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.Set("k", "v")
+		}
+	})
+}
+
+func BenchmarkMemo_Del(b *testing.B) {
+	m := memo.New[string, string]()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.Del("k")
+		}
+	})
 }
 
 type generator struct {
@@ -0,0 +1,92 @@
+package memo
+
+import (
+	"context"
+	"time"
+)
+
+// Meta describes a value in the memo.
+type Meta struct {
+	// The time the value is loaded or set.
+	LoadedAt time.Time
+	// The remaining lifetime of the value, 0 means it never
+	// expires, and DontCache means it is not cached.
+	TTL time.Duration
+}
+
+// GetWithMeta is the same as Get, except that it also describes the
+// returned value, the time is mapped from the clock to the wall clock.
+func (m *Memo[K, V]) GetWithMeta(k K, opts ...GetOption[K, V]) (V, Meta, error) {
+	var meta Meta
+
+	v, err := m.get(context.Background(), k, m.o.newGetOptions(opts...), &meta)
+
+	return v, meta, err
+}
+
+// Peek returns the value of the key if it is present, it never loads,
+// and has no side effect, neither on the policy nor on the statistics,
+// and expired entries are not removed.
+func (m *Memo[K, V]) Peek(k K) (V, bool) {
+	now := m.o.clock.Now()
+
+	s := m.shard(k)
+	s.mu.Lock()
+	defer s.unlock()
+
+	e := s.c.dictGet(k)
+	if !visible(e, now) {
+		var zero V
+
+		return zero, false
+	}
+
+	return e.value, true
+}
+
+// TTL returns the remaining lifetime of the value of the key if it is
+// present, 0 means it never expires. Like Peek, it has no side effect.
+func (m *Memo[K, V]) TTL(k K) (time.Duration, bool) {
+	now := m.o.clock.Now()
+
+	s := m.shard(k)
+	s.mu.Lock()
+	defer s.unlock()
+
+	e := s.c.dictGet(k)
+	if !visible(e, now) {
+		return 0, false
+	}
+
+	return m.meta(s, e, now).TTL, true
+}
+
+// visible reports whether the entry holds a value which is not
+// expired at now, s.mu must be held.
+func visible[K comparable, V any](e *entry[K, V], now int64) bool {
+	return e != nil && !e.loading() && e.err == nil && !e.expired(now)
+}
+
+// describe returns the meta of the entry.
+func (m *Memo[K, V]) describe(s *shard[K, V], e *entry[K, V]) Meta {
+	now := m.o.clock.Now()
+
+	s.mu.Lock()
+	defer s.unlock()
+
+	return m.meta(s, e, now)
+}
+
+// meta returns the meta of the entry at now, s.mu must be held.
+func (m *Memo[K, V]) meta(s *shard[K, V], e *entry[K, V], now int64) Meta {
+	ttl := DontCache
+
+	if s.c.dictGet(e.key) == e {
+		ttl = 0
+		if e.expireAt != zeroExpireAt {
+			ttl = time.Duration(max(0, e.expireAt-now))
+		}
+	}
+
+	return Meta{LoadedAt: time.Now().Add(time.Duration(e.loadedAt - now)), TTL: ttl}
+}
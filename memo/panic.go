@@ -0,0 +1,40 @@
+package memo
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// A PanicError is returned to every caller waiting on a load in which
+// the loader panicked, the key is not cached so the next Get retries.
+type PanicError struct {
+	// Value passed to panic.
+	Value any
+	// Stack of the goroutine that panicked.
+	Stack []byte
+}
+
+// Error returns the panic value followed by the stack.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("memo: loader panicked: %v\n\n%s", e.Value, e.Stack)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+
+	return err
+}
+
+// protect calls fn and converts a panic in it into a *PanicError.
+func protect[T any](fn func() (T, error)) (v T, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			var zero T
+
+			v, err = zero, &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	return fn()
+}
@@ -0,0 +1,404 @@
+package memo
+
+// A Policy decides which entry should be evicted when
+// the number or the total weight of entries exceeds the limit.
+type Policy int
+
+const (
+	// LRU evicts the least recently used entry.
+	LRU Policy = iota
+	// TinyLFU is the W-TinyLFU policy, a small window LRU is in front of
+	// a segmented LRU main region, and an entry evicted from the window
+	// is admitted into the main region only if it is used more frequently
+	// than the main region's victim, which is estimated by a sketch. So
+	// one-hit wonders are not able to push out hot entries.
+	TinyLFU
+)
+
+// policy is the actual implementation of Policy, all entries
+// in the cache are tracked, but the eviction is always lazy.
+type policy[K comparable, V any] interface {
+	// add tracks a new entry.
+	add(e *entry[K, V])
+	// access marks the entry as being used.
+	access(e *entry[K, V])
+	// reweigh changes the weight of the entry to w.
+	reweigh(e *entry[K, V], w int64)
+	// remove stops tracking the entry.
+	remove(e *entry[K, V])
+	// evict stops tracking and returns an entry to be evicted,
+	// nil is returned if the entries are in the limit.
+	evict() *entry[K, V]
+}
+
+func newPolicy[K comparable, V any](p Policy, l limit) policy[K, V] {
+	if l == (limit{}) {
+		return unbounded[K, V]{}
+	}
+
+	if p == TinyLFU {
+		return newTinyLFU[K, V](l)
+	}
+
+	return newLRU[K, V](l)
+}
+
+// limit is the maximum number and total weight of entries,
+// 0 means unlimited in that dimension.
+type limit struct {
+	entries int
+	weight  int64
+}
+
+// exceeded reports whether the entries with the number
+// and total weight exceed the limit.
+func (l limit) exceeded(entries int, weight int64) bool {
+	return l.entries > 0 && entries > l.entries || l.weight > 0 && weight > l.weight
+}
+
+// scale returns a limit which is the fraction of it, the
+// result is at least 1 in each limited dimension.
+func (l limit) scale(numerator, denominator int) limit {
+	var s limit
+
+	if l.entries > 0 {
+		s.entries = max(1, l.entries*numerator/denominator)
+	}
+
+	if l.weight > 0 {
+		s.weight = max(1, l.weight*int64(numerator)/int64(denominator))
+	}
+
+	return s
+}
+
+// overweight reports whether an entry of the weight alone
+// exceeds the weight limit.
+func (l limit) overweight(w int64) bool {
+	return l.weight > 0 && w > l.weight
+}
+
+// share returns the part of the limit for the i-th of n shards, it is
+// divided equally, and the remainder goes to the first shards, so the
+// parts add up to the limit. Each limited dimension must be at least n.
+func (l limit) share(i, n int) limit {
+	s := limit{entries: l.entries / n, weight: l.weight / int64(n)}
+
+	if i < l.entries%n {
+		s.entries++
+	}
+
+	if int64(i) < l.weight%int64(n) {
+		s.weight++
+	}
+
+	return s
+}
+
+// minus returns the difference of two limits in each limited dimension.
+func (l limit) minus(o limit) limit {
+	return limit{entries: max(0, l.entries-o.entries), weight: max(0, l.weight-o.weight)}
+}
+
+// unbounded never evicts entries.
+type unbounded[K comparable, V any] struct{}
+
+func (unbounded[K, V]) add(*entry[K, V]) {}
+
+func (unbounded[K, V]) access(*entry[K, V]) {}
+
+func (unbounded[K, V]) reweigh(e *entry[K, V], w int64) {
+	e.weight = w
+}
+
+func (unbounded[K, V]) remove(*entry[K, V]) {}
+
+func (unbounded[K, V]) evict() *entry[K, V] {
+	return nil
+}
+
+// lru evicts the least recently used entry.
+type lru[K comparable, V any] struct {
+	limit limit
+	q     queue[K, V]
+	// The entries heavier than the limit, see heavy.
+	heavy queue[K, V]
+}
+
+func newLRU[K comparable, V any](l limit) *lru[K, V] {
+	p := &lru[K, V]{limit: l}
+	p.q.init()
+	p.heavy.init()
+
+	return p
+}
+
+func (p *lru[K, V]) add(e *entry[K, V]) {
+	if p.limit.overweight(e.weight) {
+		p.heavy.pushFront(e)
+		e.segment = heavy
+
+		return
+	}
+
+	p.q.pushFront(e)
+	e.segment = window
+}
+
+func (p *lru[K, V]) access(e *entry[K, V]) {
+	if e.segment != heavy {
+		p.q.moveToFront(e)
+	}
+}
+
+func (p *lru[K, V]) reweigh(e *entry[K, V], w int64) {
+	if e.segment == heavy {
+		p.heavy.reweigh(e, w)
+
+		return
+	}
+
+	p.q.reweigh(e, w)
+
+	if p.limit.overweight(w) {
+		p.q.remove(e)
+		p.heavy.pushFront(e)
+		e.segment = heavy
+	}
+}
+
+func (p *lru[K, V]) remove(e *entry[K, V]) {
+	if e.segment == heavy {
+		p.heavy.remove(e)
+
+		return
+	}
+
+	p.q.remove(e)
+}
+
+func (p *lru[K, V]) evict() *entry[K, V] {
+	if p.heavy.size > 0 {
+		e := p.heavy.back()
+		p.heavy.remove(e)
+
+		return e
+	}
+
+	if !p.limit.exceeded(p.q.size, p.q.weight) {
+		return nil
+	}
+
+	e := p.q.back()
+	p.q.remove(e)
+
+	return e
+}
+
+// The segments of W-TinyLFU, and heavy for the entries heavier than
+// the weight limit in any policy, which are evicted first on their own,
+// since keeping one of them would push out every other entry and still
+// exceed the limit.
+const (
+	window uint8 = iota
+	probation
+	protected
+	heavy
+)
+
+// tinyLFU is the W-TinyLFU policy, which is described in the paper
+// [TinyLFU: A Highly Efficient Cache Admission Policy](https://arxiv.org/abs/1512.00727).
+type tinyLFU[K comparable, V any] struct {
+	// The limit of each segment and the whole.
+	windowLimit    limit
+	protectedLimit limit
+	limit          limit
+	// The window LRU, new entries are always added here.
+	window queue[K, V]
+	// The main SLRU, entries are admitted to probation at
+	// first, and promoted to protected when accessed again.
+	probation queue[K, V]
+	protected queue[K, V]
+	// The entries heavier than the limit, see heavy.
+	heavy queue[K, V]
+	// The sketch to estimate the frequency of keys.
+	sketch *sketch[K]
+}
+
+func newTinyLFU[K comparable, V any](l limit) *tinyLFU[K, V] {
+	windowLimit := l.scale(1, 100)
+	p := &tinyLFU[K, V]{
+		windowLimit:    windowLimit,
+		protectedLimit: l.minus(windowLimit).scale(4, 5),
+		limit:          l,
+		// Without a limit of entries, the sketch is sized as if
+		// each entry has an average weight of 1KiB.
+		sketch: newSketch[K](max(l.entries, int(l.weight>>10))),
+	}
+	p.window.init()
+	p.probation.init()
+	p.protected.init()
+	p.heavy.init()
+
+	return p
+}
+
+func (p *tinyLFU[K, V]) add(e *entry[K, V]) {
+	p.sketch.increment(e.key)
+
+	if p.limit.overweight(e.weight) {
+		p.heavy.pushFront(e)
+		e.segment = heavy
+
+		return
+	}
+
+	p.window.pushFront(e)
+	e.segment = window
+}
+
+func (p *tinyLFU[K, V]) access(e *entry[K, V]) {
+	p.sketch.increment(e.key)
+
+	switch e.segment {
+	case window:
+		p.window.moveToFront(e)
+	case probation:
+		p.probation.remove(e)
+		p.protected.pushFront(e)
+		e.segment = protected
+		p.demote()
+	case protected:
+		p.protected.moveToFront(e)
+	}
+}
+
+func (p *tinyLFU[K, V]) reweigh(e *entry[K, V], w int64) {
+	q := p.queueOf(e)
+	q.reweigh(e, w)
+
+	switch {
+	case e.segment != heavy && p.limit.overweight(w):
+		q.remove(e)
+		p.heavy.pushFront(e)
+		e.segment = heavy
+	case e.segment == protected:
+		p.demote()
+	}
+}
+
+func (p *tinyLFU[K, V]) remove(e *entry[K, V]) {
+	p.queueOf(e).remove(e)
+}
+
+func (p *tinyLFU[K, V]) evict() *entry[K, V] {
+	if p.heavy.size > 0 {
+		e := p.heavy.back()
+		p.heavy.remove(e)
+
+		return e
+	}
+
+	for p.window.size > 1 && p.windowLimit.exceeded(p.window.size, p.window.weight) {
+		candidate := p.window.back()
+		p.window.remove(candidate)
+		p.probation.pushFront(candidate)
+		candidate.segment = probation
+	}
+
+	size := p.window.size + p.probation.size + p.protected.size
+	weight := p.window.weight + p.probation.weight + p.protected.weight
+
+	if !p.limit.exceeded(size, weight) {
+		return nil
+	}
+
+	// The main region may be empty if the window is heavy.
+	q := &p.probation
+	if q.size == 0 {
+		q = &p.protected
+	}
+
+	if q.size == 0 {
+		q = &p.window
+	}
+
+	candidate, victim := q.front(), q.back()
+	if candidate != victim && p.sketch.frequency(candidate.key) > p.sketch.frequency(victim.key) {
+		candidate = victim
+	}
+
+	q.remove(candidate)
+
+	return candidate
+}
+
+// demote moves entries from protected to probation
+// until the protected segment is in its limit.
+func (p *tinyLFU[K, V]) demote() {
+	for p.protected.size > 1 && p.protectedLimit.exceeded(p.protected.size, p.protected.weight) {
+		demoted := p.protected.back()
+		p.protected.remove(demoted)
+		p.probation.pushFront(demoted)
+		demoted.segment = probation
+	}
+}
+
+func (p *tinyLFU[K, V]) queueOf(e *entry[K, V]) *queue[K, V] {
+	switch e.segment {
+	case window:
+		return &p.window
+	case probation:
+		return &p.probation
+	case protected:
+		return &p.protected
+	default:
+		return &p.heavy
+	}
+}
+
+// queue is an intrusive doubly linked list of entries, the root
+// is a sentinel, root.next is the front and root.prev is the back.
+type queue[K comparable, V any] struct {
+	root   entry[K, V]
+	size   int
+	weight int64
+}
+
+func (q *queue[K, V]) init() {
+	q.root.prev, q.root.next = &q.root, &q.root
+}
+
+func (q *queue[K, V]) front() *entry[K, V] {
+	return q.root.next
+}
+
+func (q *queue[K, V]) back() *entry[K, V] {
+	return q.root.prev
+}
+
+func (q *queue[K, V]) pushFront(e *entry[K, V]) {
+	e.prev, e.next = &q.root, q.root.next
+	e.prev.next, e.next.prev = e, e
+	q.size++
+	q.weight += e.weight
+}
+
+func (q *queue[K, V]) remove(e *entry[K, V]) {
+	e.prev.next, e.next.prev = e.next, e.prev
+	e.prev, e.next = nil, nil
+	q.size--
+	q.weight -= e.weight
+}
+
+func (q *queue[K, V]) moveToFront(e *entry[K, V]) {
+	if q.root.next != e {
+		q.remove(e)
+		q.pushFront(e)
+	}
+}
+
+func (q *queue[K, V]) reweigh(e *entry[K, V], w int64) {
+	q.weight += w - e.weight
+	e.weight = w
+}
@@ -0,0 +1,43 @@
+package memo
+
+// A RemovalReason is the reason why an entry is removed from the memo.
+type RemovalReason int
+
+const (
+	// Expired means the entry is removed because of expiration.
+	Expired RemovalReason = iota
+	// Deleted means the entry is removed by memo.Del.
+	Deleted
+	// Replaced means the value of the entry is replaced by
+	// memo.Set or a refresh, the key is still in the memo.
+	Replaced
+	// Evicted means the entry is evicted by the policy.
+	Evicted
+)
+
+// String returns the name of the reason.
+func (r RemovalReason) String() string {
+	switch r {
+	case Expired:
+		return "expired"
+	case Deleted:
+		return "deleted"
+	case Replaced:
+		return "replaced"
+	case Evicted:
+		return "evicted"
+	default:
+		return "unknown"
+	}
+}
+
+// A RemovalListener is notified when a value leaves the memo, it is
+// invoked without holding any lock, so it can safely access the memo.
+type RemovalListener[K comparable, V any] func(k K, v V, reason RemovalReason)
+
+// removal is a pending notification of RemovalListener.
+type removal[K comparable, V any] struct {
+	key    K
+	value  V
+	reason RemovalReason
+}
@@ -0,0 +1,103 @@
+package memo
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// A RetryPolicy decides how a failed load is retried, the retries happen
+// within the single in-flight load, so callers of the same key keep
+// waiting on it. The backoff before the n-th retry is InitialBackoff *
+// Multiplier^(n-1), capped at MaxBackoff, and reduced by a random part
+// of at most Jitter of it. The backoff is cut short when the deadline of
+// the context of the load is exceeded.
+type RetryPolicy struct {
+	// Maximum number of attempts, including the first one.
+	MaxAttempts int
+	// Backoff before the first retry.
+	InitialBackoff time.Duration
+	// Maximum backoff, 0 means 30 seconds.
+	MaxBackoff time.Duration
+	// Factor the backoff grows by, 0 means 2.
+	Multiplier float64
+	// Fraction of the backoff randomized, in the range [0, 1].
+	Jitter float64
+	// Retryable reports whether an error should be retried, nil means
+	// all errors except ErrNotFound. Context errors and panics are
+	// never retried.
+	Retryable ErrorPredicate
+}
+
+// valid reports whether the policy is valid.
+func (p *RetryPolicy) valid() bool {
+	return p.MaxAttempts >= 1 && p.InitialBackoff >= 0 && p.MaxBackoff >= 0 &&
+		(p.Multiplier == 0 || p.Multiplier >= 1) && p.Jitter >= 0 && p.Jitter <= 1
+}
+
+// retryable reports whether the error should be retried.
+func (p *RetryPolicy) retryable(err error) bool {
+	var pe *PanicError
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.As(err, &pe) {
+		return false
+	}
+
+	if p.Retryable == nil {
+		return !errors.Is(err, ErrNotFound)
+	}
+
+	return p.Retryable(err)
+}
+
+// defaultMaxBackoff is the maximum backoff if it is not set, the
+// callers waiting on a load must not be stuck on an endless backoff.
+const defaultMaxBackoff = 30 * time.Second
+
+// backoff returns the backoff before the n-th retry.
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	limit := float64(p.MaxBackoff)
+	if p.MaxBackoff == 0 {
+		limit = float64(defaultMaxBackoff)
+	}
+
+	d := float64(p.InitialBackoff)
+	for i := 1; i < n && d < limit; i++ {
+		d *= multiplier
+	}
+
+	d = min(d, limit) * (1 - p.Jitter*rand.Float64())
+
+	// float64(math.MaxInt64) is rounded up out of the range.
+	if d >= float64(math.MaxInt64) {
+		return math.MaxInt64
+	}
+
+	return time.Duration(d)
+}
+
+// retry calls fn until it succeeds, or the error is not retryable, or
+// the attempts are used up, sleeping on the clock between attempts.
+// The error of the last attempt is returned.
+func retry[T any](ctx context.Context, clock Clock, p *RetryPolicy, fn func() (T, error)) (T, error) {
+	v, err := fn()
+	if p == nil {
+		return v, err
+	}
+
+	for n := 1; n < p.MaxAttempts && err != nil && p.retryable(err); n++ {
+		if sleepOn(ctx, clock, p.backoff(n)) != nil {
+			break
+		}
+
+		v, err = fn()
+	}
+
+	return v, err
+}
@@ -0,0 +1,139 @@
+package memo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// shard is a partition of memo, which guards its cache by a lock.
+type shard[K comparable, V any] struct {
+	mu       sync.Mutex
+	c        *cache[K, V]
+	counters counters
+	// The listener and its pending notifications, which
+	// are delivered after the lock is released.
+	onRemove RemovalListener[K, V]
+	removals []removal[K, V]
+	// The store and its pending writes, which are also applied
+	// after the lock is released, one at a time in the order they
+	// are added, by the caller who finds no one else flushing.
+	tier     *tier[K, V]
+	writes   []write[K, V]
+	flushing bool
+}
+
+func newShard[K comparable, V any](p policy[K, V], x expiry[K, V], onRemove RemovalListener[K, V], t *tier[K, V]) *shard[K, V] {
+	return &shard[K, V]{c: newCache[K, V](p, x), onRemove: onRemove, tier: t}
+}
+
+// unlock releases the lock, delivers pending notifications
+// and applies pending writes to the store.
+func (s *shard[K, V]) unlock() {
+	removals := s.removals
+	s.removals = nil
+	flush := len(s.writes) != 0 && !s.flushing
+	s.flushing = s.flushing || flush
+	s.mu.Unlock()
+
+	for _, r := range removals {
+		s.onRemove(r.key, r.value, r.reason)
+	}
+
+	if flush {
+		s.flush()
+	}
+}
+
+// flush applies pending writes to the store until there is none, so
+// that a slow write delays the later ones instead of being overtaken.
+func (s *shard[K, V]) flush() {
+	for {
+		s.mu.Lock()
+		writes := s.writes
+		s.writes = nil
+		s.flushing = len(writes) != 0
+		s.mu.Unlock()
+
+		if len(writes) == 0 {
+			return
+		}
+
+		for _, w := range writes {
+			s.tier.apply(context.Background(), w)
+		}
+	}
+}
+
+// writeThrough adds a pending write of the key-value pair
+// to the store, s.mu must be held.
+func (s *shard[K, V]) writeThrough(k K, v V, ttl time.Duration) {
+	if s.tier != nil {
+		s.writes = append(s.writes, write[K, V]{key: k, value: v, ttl: ttl})
+	}
+}
+
+// deleteThrough adds a pending deletion of the key
+// from the store, s.mu must be held.
+func (s *shard[K, V]) deleteThrough(k K) {
+	if s.tier != nil {
+		s.writes = append(s.writes, write[K, V]{key: k, del: true})
+	}
+}
+
+// record adds a pending notification for the entry, only entries
+// holding a value are notified, s.mu must be held.
+func (s *shard[K, V]) record(e *entry[K, V], reason RemovalReason) {
+	if s.onRemove != nil && !e.loading() && e.err == nil {
+		s.removals = append(s.removals, removal[K, V]{key: e.key, value: e.value, reason: reason})
+	}
+}
+
+// cleanup removes entries expired before now, which are all of
+// them for a heap, but some may be left behind for a timing wheel.
+func (s *shard[K, V]) cleanup(now int64) {
+	for e := s.c.expiryPop(now); e != nil; e = s.c.expiryPop(now) {
+		s.counters.expirations.Add(1)
+		s.remove(e, Expired)
+	}
+}
+
+// lookup returns the entry of the key, or nil if it is absent, the
+// entry is removed if it is expired but left behind by cleanup.
+func (s *shard[K, V]) lookup(k K, now int64) *entry[K, V] {
+	e := s.c.dictGet(k)
+	if e != nil && e.expired(now) {
+		s.counters.expirations.Add(1)
+		s.remove(e, Expired)
+
+		return nil
+	}
+
+	return e
+}
+
+// expire sets the expiration of the entry, which lives for
+// expiration since now, 0 means it never expires.
+func (s *shard[K, V]) expire(e *entry[K, V], now int64, expiration time.Duration) {
+	e.lifetime = expiration
+	s.c.expirySchedule(e, expireAt(now, expiration))
+}
+
+// remove removes the entry from the cache for the reason.
+func (s *shard[K, V]) remove(e *entry[K, V], reason RemovalReason) {
+	s.c.expiryRemove(e)
+	s.c.policyRemove(e)
+	s.c.dictDel(e.key)
+	s.record(e, reason)
+}
+
+// evict removes entries chosen by the policy until
+// the number of entries is no more than the limit.
+func (s *shard[K, V]) evict() {
+	for e := s.c.policyEvict(); e != nil; e = s.c.policyEvict() {
+		s.c.expiryRemove(e)
+		s.c.dictDel(e.key)
+		s.counters.evictions.Add(1)
+		s.record(e, Evicted)
+	}
+}
@@ -0,0 +1,126 @@
+package memo
+
+import (
+	"hash/maphash"
+	"math/bits"
+)
+
+// sketch is a count-min sketch with 4-bit counters to estimate the
+// frequency of keys, all counters are halved periodically so that
+// the history is aged. A doorkeeper is placed in front of it, keys
+// are counted by the sketch only if they have been seen before.
+type sketch[K comparable] struct {
+	seed maphash.Seed
+	// The counters, each uint64 holds 16 counters.
+	table []uint64
+	// The doorkeeper, a bitset acts as a Bloom filter.
+	door []uint64
+	// The mask to locate a counter or a bit.
+	mask uint64
+	// Number of increments since the last reset.
+	additions int
+	// Reset is performed when additions reach it.
+	sampleSize int
+}
+
+const (
+	sketchDepth   = 4
+	sketchMaxFreq = 15
+)
+
+func newSketch[K comparable](capacity int) *sketch[K] {
+	width := 1 << bits.Len(uint(max(capacity, 16)-1))
+
+	// Both the counters and the bits of the doorkeeper are
+	// located by the mask, so each has width*sketchDepth slots.
+	return &sketch[K]{
+		seed:       maphash.MakeSeed(),
+		table:      make([]uint64, max(1, width*sketchDepth/16)),
+		door:       make([]uint64, max(1, width*sketchDepth/64)),
+		mask:       uint64(width*sketchDepth - 1),
+		sampleSize: 10 * width,
+	}
+}
+
+func (s *sketch[K]) increment(k K) {
+	h := maphash.Comparable(s.seed, k)
+
+	if !s.doorAdd(h) {
+		for i := range sketchDepth {
+			s.counterIncrement(s.index(h, i))
+		}
+	}
+
+	if s.additions++; s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+func (s *sketch[K]) frequency(k K) int {
+	h := maphash.Comparable(s.seed, k)
+
+	freq := sketchMaxFreq
+	for i := range sketchDepth {
+		freq = min(freq, s.counterGet(s.index(h, i)))
+	}
+
+	if s.doorContains(h) {
+		freq++
+	}
+
+	return freq
+}
+
+// index returns the position of the i-th hash of h.
+func (s *sketch[K]) index(h uint64, i int) uint64 {
+	h = (h + uint64(i)) * 0x9e3779b97f4a7c15
+	h ^= h >> 32
+
+	return h & s.mask
+}
+
+func (s *sketch[K]) counterGet(i uint64) int {
+	return int(s.table[i>>4] >> ((i & 15) << 2) & 15)
+}
+
+func (s *sketch[K]) counterIncrement(i uint64) {
+	if s.counterGet(i) < sketchMaxFreq {
+		s.table[i>>4] += 1 << ((i & 15) << 2)
+	}
+}
+
+// doorAdd adds h into the doorkeeper, returns whether it is newly added.
+func (s *sketch[K]) doorAdd(h uint64) bool {
+	added := false
+
+	for i := range sketchDepth {
+		j := s.index(h, i)
+		if s.door[j>>6]&(1<<(j&63)) == 0 {
+			s.door[j>>6] |= 1 << (j & 63)
+			added = true
+		}
+	}
+
+	return added
+}
+
+func (s *sketch[K]) doorContains(h uint64) bool {
+	for i := range sketchDepth {
+		j := s.index(h, i)
+		if s.door[j>>6]&(1<<(j&63)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// reset halves all counters and clears the doorkeeper.
+func (s *sketch[K]) reset() {
+	for i := range s.table {
+		s.table[i] = s.table[i] >> 1 & 0x7777777777777777
+	}
+
+	clear(s.door)
+	s.additions = 0
+}
@@ -0,0 +1,221 @@
+package memo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrInvalidSnapshot represents an invalid snapshot error.
+var ErrInvalidSnapshot = errors.New("memo: invalid snapshot")
+
+// A Codec encodes and decodes keys and values in snapshots.
+type Codec[K comparable, V any] interface {
+	EncodeKey(k K) ([]byte, error)
+	DecodeKey(b []byte) (K, error)
+	EncodeValue(v V) ([]byte, error)
+	DecodeValue(b []byte) (V, error)
+}
+
+// JSONCodec is a Codec based on encoding/json.
+type JSONCodec[K comparable, V any] struct{}
+
+// EncodeKey encodes the key as JSON.
+func (JSONCodec[K, V]) EncodeKey(k K) ([]byte, error) {
+	return json.Marshal(k)
+}
+
+// DecodeKey decodes the key from JSON.
+func (JSONCodec[K, V]) DecodeKey(b []byte) (K, error) {
+	var k K
+
+	err := json.Unmarshal(b, &k)
+
+	return k, err
+}
+
+// EncodeValue encodes the value as JSON.
+func (JSONCodec[K, V]) EncodeValue(v V) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// DecodeValue decodes the value from JSON.
+func (JSONCodec[K, V]) DecodeValue(b []byte) (V, error) {
+	var v V
+
+	err := json.Unmarshal(b, &v)
+
+	return v, err
+}
+
+// The snapshot begins with a header, which is the magic followed by the
+// wall time of taking it in unix nanoseconds, and then the records, each
+// record is the remaining lifetime in nanoseconds(0 means no expiration),
+// the length of key, the key, the length of value and the value, all the
+// integers are encoded as uvarint.
+const snapshotMagic = "memo\x01"
+
+// maxRecordBytes is the limit of the length of key or value in
+// a snapshot, to avoid allocating huge memory for broken data.
+const maxRecordBytes = 1 << 30
+
+// Snapshot writes all entries holding values into w, with their remaining
+// lifetime, entries which are expired, being loaded or holding errors are
+// skipped. Shards are written one by one, so it does not stop the world.
+func (m *Memo[K, V]) Snapshot(w io.Writer, codec Codec[K, V]) error {
+	bw := bufio.NewWriter(w)
+	buf := make([]byte, 0, binary.MaxVarintLen64)
+
+	if _, err := bw.WriteString(snapshotMagic); err != nil {
+		return err
+	}
+
+	if _, err := bw.Write(binary.AppendUvarint(buf, uint64(time.Now().UnixNano()))); err != nil {
+		return err
+	}
+
+	for _, s := range m.shards {
+		now := m.o.clock.Now()
+
+		for _, p := range m.snapshot(s, now) {
+			kb, err := codec.EncodeKey(p.key)
+			if err != nil {
+				return fmt.Errorf("memo: encode key: %w", err)
+			}
+
+			vb, err := codec.EncodeValue(p.value)
+			if err != nil {
+				return fmt.Errorf("memo: encode value: %w", err)
+			}
+
+			var ttl int64
+			if p.expireAt != zeroExpireAt {
+				ttl = p.expireAt - now
+			}
+
+			record := binary.AppendUvarint(buf, uint64(ttl))
+			record = binary.AppendUvarint(record, uint64(len(kb)))
+			record = append(record, kb...)
+			record = binary.AppendUvarint(record, uint64(len(vb)))
+			record = append(record, vb...)
+
+			if _, err := bw.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Restore reads entries from r which is written by Snapshot, and sets
+// them into the memo with their remaining lifetime, the time elapsed
+// since the snapshot was taken is deducted, so entries expired in the
+// meantime are dropped. Keys already in the memo are newer than the
+// snapshot, so they are left untouched, and restored values are not
+// written through to the store. Entries restored before an error are
+// kept.
+func (m *Memo[K, V]) Restore(r io.Reader, codec Codec[K, V]) error {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != snapshotMagic {
+		return fmt.Errorf("%w: bad magic", ErrInvalidSnapshot)
+	}
+
+	taken, err := binary.ReadUvarint(br)
+	if err != nil {
+		return fmt.Errorf("%w: bad header: %w", ErrInvalidSnapshot, err)
+	}
+
+	elapsed := max(0, time.Since(time.Unix(0, int64(taken))))
+
+	for {
+		ttl, err := binary.ReadUvarint(br)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w: bad record: %w", ErrInvalidSnapshot, err)
+		}
+
+		kb, err := readBytes(br)
+		if err != nil {
+			return fmt.Errorf("%w: bad key: %w", ErrInvalidSnapshot, err)
+		}
+
+		vb, err := readBytes(br)
+		if err != nil {
+			return fmt.Errorf("%w: bad value: %w", ErrInvalidSnapshot, err)
+		}
+
+		expiration := time.Duration(ttl)
+		if expiration != 0 {
+			if expiration <= elapsed {
+				continue
+			}
+
+			expiration -= elapsed
+		}
+
+		k, err := codec.DecodeKey(kb)
+		if err != nil {
+			return fmt.Errorf("memo: decode key: %w", err)
+		}
+
+		v, err := codec.DecodeValue(vb)
+		if err != nil {
+			return fmt.Errorf("memo: decode value: %w", err)
+		}
+
+		m.restore(k, v, expiration)
+	}
+}
+
+// restore sets the key-value pair read from a snapshot if the key is
+// absent from the memo.
+func (m *Memo[K, V]) restore(k K, v V, expiration time.Duration) {
+	now := m.o.clock.Now()
+
+	s := m.shard(k)
+	s.mu.Lock()
+	defer s.unlock()
+	s.cleanup(now)
+
+	if s.lookup(k, now) == nil {
+		m.insert(s, k, v, setOptions[K, V]{expiration: expiration}, now)
+	}
+}
+
+// readBytes reads a uvarint length and then the bytes.
+func readBytes(br *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, noEOF(err)
+	}
+
+	if n > maxRecordBytes {
+		return nil, fmt.Errorf("length %v is too large", n)
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return nil, noEOF(err)
+	}
+
+	return b, nil
+}
+
+// noEOF converts io.EOF into io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
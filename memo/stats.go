@@ -0,0 +1,103 @@
+package memo
+
+import (
+	"expvar"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the statistics of a memo.
+type Stats struct {
+	// Number of times a key is found, including being loaded.
+	Hits uint64
+	// Number of times a key is not found(or expired).
+	Misses uint64
+	// Number of times the loader or bulk loader succeeds.
+	LoadSuccesses uint64
+	// Number of times the loader or bulk loader fails.
+	LoadFailures uint64
+	// Total time spent in the loader and bulk loader.
+	TotalLoadTime time.Duration
+	// Number of entries removed because of expiration.
+	Expirations uint64
+	// Number of entries evicted by the policy.
+	Evictions uint64
+	// Number of entries currently in the memo.
+	Size int
+	// Total weight of entries currently in the memo.
+	Weight int64
+}
+
+// HitRatio returns the ratio of hits to requests, 1 if no requests.
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 1
+	}
+
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// counters holds the statistics, the counters on hot paths are kept
+// by each shard, while the counters of loads are kept by the memo.
+type counters struct {
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	loadSuccesses atomic.Uint64
+	loadFailures  atomic.Uint64
+	loadTime      atomic.Int64
+	expirations   atomic.Uint64
+	evictions     atomic.Uint64
+}
+
+// addTo adds the counters to the snapshot.
+func (c *counters) addTo(s *Stats) {
+	s.Hits += c.hits.Load()
+	s.Misses += c.misses.Load()
+	s.LoadSuccesses += c.loadSuccesses.Load()
+	s.LoadFailures += c.loadFailures.Load()
+	s.TotalLoadTime += time.Duration(c.loadTime.Load())
+	s.Expirations += c.expirations.Load()
+	s.Evictions += c.evictions.Load()
+}
+
+// Stats returns a snapshot of the statistics.
+func (m *Memo[K, V]) Stats() Stats {
+	var stats Stats
+
+	m.counters.addTo(&stats)
+
+	for _, s := range m.shards {
+		s.counters.addTo(&stats)
+
+		s.mu.Lock()
+		stats.Size += len(s.c.dict)
+		stats.Weight += s.c.weight
+		s.mu.Unlock()
+	}
+
+	return stats
+}
+
+// Var returns an expvar.Var which reports the statistics as JSON.
+func (m *Memo[K, V]) Var() expvar.Var {
+	return expvar.Func(func() any {
+		return m.Stats()
+	})
+}
+
+// Publish publishes the statistics to expvar with the name, so they
+// show up on /debug/vars, it panics if the name is already in use.
+func (m *Memo[K, V]) Publish(name string) {
+	expvar.Publish(name, m.Var())
+}
+
+// recordLoad records the result of a load started at start.
+func (m *Memo[K, V]) recordLoad(start int64, err error) {
+	m.counters.loadTime.Add(m.o.clock.Now() - start)
+
+	if err != nil {
+		m.counters.loadFailures.Add(1)
+	} else {
+		m.counters.loadSuccesses.Add(1)
+	}
+}
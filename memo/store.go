@@ -0,0 +1,170 @@
+package memo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// A Store is a second tier behind the memo, which is usually shared by
+// processes, e.g. Redis. On a miss, the memo gets the value from the
+// store before invoking the loader, and the loaded value is written to
+// the store. Without a loader, the errors of the store, ErrNotFound
+// included, are returned but not cached. Values set by memo.Set,
+// memo.Compute, memo.GetOrSet and memo.CompareAndSwap are written
+// through to the store, and keys deleted by memo.Del, memo.Compute and
+// memo.CompareAndDelete are deleted through. Other removals only affect
+// the memo. Writes happen after the memo is updated, in the order of
+// the updates, and their errors are only reported to the handler
+// provided by WithOnStoreError. A write may be applied by a later
+// caller of the memo, while the caller who made it has returned.
+type Store[K comparable, V any] interface {
+	// Get returns the value of the key and its remaining lifetime,
+	// 0 means it never expires, ErrNotFound is returned if the key
+	// does not exist.
+	Get(ctx context.Context, k K) (V, time.Duration, error)
+	// Set sets the value of the key which expires after ttl,
+	// 0 means it never expires.
+	Set(ctx context.Context, k K, v V, ttl time.Duration) error
+	// Del deletes the key, it is not an error if the key does not exist.
+	Del(ctx context.Context, k K) error
+}
+
+// tier wraps the store of a memo with its error handler.
+type tier[K comparable, V any] struct {
+	store   Store[K, V]
+	onError func(K, error)
+}
+
+func newTier[K comparable, V any](store Store[K, V], onError func(K, error)) *tier[K, V] {
+	if store == nil {
+		return nil
+	}
+
+	return &tier[K, V]{store: store, onError: onError}
+}
+
+// get gets the value of the key from the store, ErrNotFound
+// is returned if there is no store.
+func (t *tier[K, V]) get(ctx context.Context, k K) (V, time.Duration, error) {
+	if t == nil {
+		var zero V
+
+		return zero, 0, ErrNotFound
+	}
+
+	v, ttl, err := t.store.Get(ctx, k)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		t.fail(k, err)
+	}
+
+	return v, ttl, err
+}
+
+// apply writes to the store.
+func (t *tier[K, V]) apply(ctx context.Context, w write[K, V]) {
+	if t == nil {
+		return
+	}
+
+	var err error
+	if w.del {
+		err = t.store.Del(ctx, w.key)
+	} else {
+		err = t.store.Set(ctx, w.key, w.value, w.ttl)
+	}
+
+	if err != nil {
+		t.fail(w.key, err)
+	}
+}
+
+func (t *tier[K, V]) fail(k K, err error) {
+	if t.onError != nil {
+		t.onError(k, err)
+	}
+}
+
+// write is a pending write to the store.
+type write[K comparable, V any] struct {
+	key   K
+	value V
+	ttl   time.Duration
+	del   bool
+}
+
+// within returns the expiration bounded by the remaining lifetime of
+// a value in the store, 0 means no bound for both.
+func within(expiration, ttl time.Duration) time.Duration {
+	if ttl > 0 && (expiration == 0 || ttl < expiration) {
+		return ttl
+	}
+
+	return expiration
+}
+
+// A MapStore is an in-process Store backed by a map, it is safe for
+// concurrent use, and serves as a reference implementation and a
+// stand-in for a shared store in tests.
+type MapStore[K comparable, V any] struct {
+	mu    sync.Mutex
+	clock Clock
+	items map[K]storeItem[V]
+}
+
+type storeItem[V any] struct {
+	value    V
+	expireAt int64
+}
+
+// NewMapStore creates a map store whose values expire by the clock.
+func NewMapStore[K comparable, V any](clock Clock) *MapStore[K, V] {
+	return &MapStore[K, V]{clock: clock, items: make(map[K]storeItem[V])}
+}
+
+// Get returns the value of the key and its remaining lifetime.
+func (ms *MapStore[K, V]) Get(_ context.Context, k K) (V, time.Duration, error) {
+	now := ms.clock.Now()
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	it, ok := ms.items[k]
+	if !ok || (it.expireAt != zeroExpireAt && it.expireAt <= now) {
+		delete(ms.items, k)
+
+		var zero V
+
+		return zero, 0, ErrNotFound
+	}
+
+	var ttl time.Duration
+	if it.expireAt != zeroExpireAt {
+		ttl = time.Duration(it.expireAt - now)
+	}
+
+	return it.value, ttl, nil
+}
+
+// Set sets the value of the key which expires after ttl.
+func (ms *MapStore[K, V]) Set(_ context.Context, k K, v V, ttl time.Duration) error {
+	now := ms.clock.Now()
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.items[k] = storeItem[V]{value: v, expireAt: expireAt(now, max(0, ttl))}
+
+	return nil
+}
+
+// Del deletes the key.
+func (ms *MapStore[K, V]) Del(_ context.Context, k K) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.items, k)
+
+	return nil
+}
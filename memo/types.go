@@ -1,6 +1,7 @@
 package memo
 
 import (
+	"context"
 	"errors"
 	"time"
 )
@@ -8,36 +9,190 @@ import (
 var (
 	// ErrNotFound is an error returned when the key is not found.
 	ErrNotFound = errors.New("memo: not found")
+	// ErrGoexit is returned to the callers waiting on a load in which
+	// the loader called runtime.Goexit, it is never cached.
+	ErrGoexit = errors.New("memo: loader called runtime.Goexit")
 	// ErrInvalidExpiration represents an invalid expiration error.
 	ErrInvalidExpiration = errors.New("memo: invalid expiration")
+	// ErrInvalidMaxEntries represents an invalid max entries error.
+	ErrInvalidMaxEntries = errors.New("memo: invalid max entries")
+	// ErrInvalidMaxWeight represents an invalid max weight error.
+	ErrInvalidMaxWeight = errors.New("memo: invalid max weight")
+	// ErrInvalidPolicy represents an invalid policy error.
+	ErrInvalidPolicy = errors.New("memo: invalid policy")
+	// ErrInvalidShards represents an invalid shards error.
+	ErrInvalidShards = errors.New("memo: invalid shards")
+	// ErrInvalidRefreshInterval represents an invalid refresh interval error.
+	ErrInvalidRefreshInterval = errors.New("memo: invalid refresh interval")
+	// ErrInvalidJanitorInterval represents an invalid janitor interval error.
+	ErrInvalidJanitorInterval = errors.New("memo: invalid janitor interval")
+	// ErrInvalidExpirationIndex represents an invalid expiration index error.
+	ErrInvalidExpirationIndex = errors.New("memo: invalid expiration index")
+	// ErrInvalidRetryPolicy represents an invalid retry policy error.
+	ErrInvalidRetryPolicy = errors.New("memo: invalid retry policy")
 )
 
 // A Loader returns the value of the key.
 type Loader[K comparable, V any] func(K) (V, error)
 
+// A LoaderContext returns the value of the key, it is the same
+// as Loader, except that it receives a context.
+type LoaderContext[K comparable, V any] func(context.Context, K) (V, error)
+
+// withContext converts the loader into a LoaderContext.
+func (loader Loader[K, V]) withContext() LoaderContext[K, V] {
+	if loader == nil {
+		return nil
+	}
+
+	return func(_ context.Context, k K) (V, error) {
+		return loader(k)
+	}
+}
+
+// DontCache is a TTL returned by a LoaderWithTTL to tell that the value
+// is returned to the callers waiting for it, but not cached.
+const DontCache time.Duration = -1
+
+// A LoaderWithTTL returns the value of the key and the TTL of the value,
+// which overrides the expiration option, 0 means it never expires, and
+// negative values, e.g. DontCache, mean the value is not cached.
+type LoaderWithTTL[K comparable, V any] func(K) (V, time.Duration, error)
+
+// A LoaderWithTTLContext returns the value of the key and the TTL of the
+// value, it is the same as LoaderWithTTL, except that it receives a context.
+type LoaderWithTTLContext[K comparable, V any] func(context.Context, K) (V, time.Duration, error)
+
+// withContext converts the loader into a LoaderWithTTLContext.
+func (loader LoaderWithTTL[K, V]) withContext() LoaderWithTTLContext[K, V] {
+	if loader == nil {
+		return nil
+	}
+
+	return func(_ context.Context, k K) (V, time.Duration, error) {
+		return loader(k)
+	}
+}
+
+// A BulkLoader returns the values of the keys, the keys absent
+// from the result are considered as not found.
+type BulkLoader[K comparable, V any] func([]K) (map[K]V, error)
+
+// A BulkLoaderContext returns the values of the keys, it is the
+// same as BulkLoader, except that it receives a context.
+type BulkLoaderContext[K comparable, V any] func(context.Context, []K) (map[K]V, error)
+
+// withContext converts the bulk loader into a BulkLoaderContext.
+func (bulkLoader BulkLoader[K, V]) withContext() BulkLoaderContext[K, V] {
+	if bulkLoader == nil {
+		return nil
+	}
+
+	return func(_ context.Context, keys []K) (map[K]V, error) {
+		return bulkLoader(keys)
+	}
+}
+
+// A Weigher returns the weight of the key-value pair, e.g. its size
+// in bytes. It is invoked while the memo is locked, so it should be
+// fast, and must not access the memo. Negative weights are taken as 0.
+type Weigher[K comparable, V any] func(K, V) int64
+
+// An ErrorPredicate reports whether an error returned by the loader
+// should be cached, errors which are not cached will be loaded again.
+type ErrorPredicate func(error) bool
+
 // options holds all extra configs needed when creating a new memo.
 type options[K comparable, V any] struct {
 	// The clock provides the current time in nanoseconds.
 	clock Clock
-	// Default loader used in memo.Get method.
-	loader Loader[K, V]
+	// Default loader used in memo.Get method, at most
+	// one of them is set.
+	loader    LoaderContext[K, V]
+	ttlLoader LoaderWithTTLContext[K, V]
+	// Default bulk loader used in memo.GetMany method.
+	bulkLoader BulkLoaderContext[K, V]
 	// Default expiration used in memo.Get and memo.Set method.
 	expiration time.Duration
+	// Default expiration for errors used in memo.Get method,
+	// the expiration is used if it is not set.
+	errorExpiration    time.Duration
+	errorExpirationSet bool
+	// Default predicate for errors used in memo.Get method.
+	errorPredicate ErrorPredicate
+	// Default sliding expiration used in memo.Get method.
+	slidingExpiration bool
+	// The maximum number of entries, 0 means unlimited.
+	maxEntries int
+	// The maximum total weight of entries, 0 means unlimited.
+	maxWeight int64
+	// The weigher to measure the weight of entries.
+	weigher Weigher[K, V]
+	// The policy to evict entries when the limit is reached.
+	policy Policy
+	// The index to find expired entries.
+	expirationIndex ExpirationIndex
+	// The listener to be notified when a value leaves the memo.
+	onRemove RemovalListener[K, V]
+	// Number of shards to partition keys.
+	shards int
+	// Interval after which values are reloaded in background by memo.Get.
+	refreshInterval time.Duration
+	// Interval of the janitor, 0 means no janitor in background.
+	janitorInterval time.Duration
+	// The second tier store, and the handler of its errors.
+	store        Store[K, V]
+	onStoreError func(K, error)
+	// The policy to retry failed loads, nil means no retry.
+	retryPolicy *RetryPolicy
 }
 
 // Option specifies the option when creating a new memo.
 type Option[K comparable, V any] func(*options[K, V])
 
 func newOptions[K comparable, V any](opts ...Option[K, V]) options[K, V] {
-	o := options[K, V]{clock: NewRealClock()}
+	o := options[K, V]{clock: NewRealClock(), shards: 1}
 	for _, opt := range opts {
 		opt(&o)
 	}
 
-	if o.expiration < 0 {
+	if o.expiration < 0 || o.errorExpiration < 0 {
 		panic(ErrInvalidExpiration)
 	}
 
+	if o.maxEntries < 0 {
+		panic(ErrInvalidMaxEntries)
+	}
+
+	if o.maxWeight < 0 {
+		panic(ErrInvalidMaxWeight)
+	}
+
+	if o.policy != LRU && o.policy != TinyLFU {
+		panic(ErrInvalidPolicy)
+	}
+
+	if o.expirationIndex != Heap && o.expirationIndex != TimingWheel {
+		panic(ErrInvalidExpirationIndex)
+	}
+
+	// Each shard takes a part of the limits, which can not be 0.
+	if o.shards <= 0 || o.maxEntries > 0 && o.maxEntries < o.shards || o.maxWeight > 0 && o.maxWeight < int64(o.shards) {
+		panic(ErrInvalidShards)
+	}
+
+	if o.refreshInterval < 0 {
+		panic(ErrInvalidRefreshInterval)
+	}
+
+	if o.janitorInterval < 0 {
+		panic(ErrInvalidJanitorInterval)
+	}
+
+	if o.retryPolicy != nil && !o.retryPolicy.valid() {
+		panic(ErrInvalidRetryPolicy)
+	}
+
 	return o
 }
 
@@ -51,7 +206,44 @@ func WithClock[K comparable, V any](clock Clock) Option[K, V] {
 // WithLoader provides a loader option when creating a new memo.
 func WithLoader[K comparable, V any](loader Loader[K, V]) Option[K, V] {
 	return func(o *options[K, V]) {
-		o.loader = loader
+		o.loader, o.ttlLoader = loader.withContext(), nil
+	}
+}
+
+// WithLoaderContext provides a context-aware loader option when creating a new memo.
+func WithLoaderContext[K comparable, V any](loader LoaderContext[K, V]) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.loader, o.ttlLoader = loader, nil
+	}
+}
+
+// WithLoaderWithTTL provides a loader option when creating a new memo,
+// the loader decides the expiration of each loaded value.
+func WithLoaderWithTTL[K comparable, V any](loader LoaderWithTTL[K, V]) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.loader, o.ttlLoader = nil, loader.withContext()
+	}
+}
+
+// WithLoaderWithTTLContext provides a context-aware loader option when
+// creating a new memo, the loader decides the expiration of each loaded value.
+func WithLoaderWithTTLContext[K comparable, V any](loader LoaderWithTTLContext[K, V]) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.loader, o.ttlLoader = nil, loader
+	}
+}
+
+// WithBulkLoader provides a bulk loader option when creating a new memo.
+func WithBulkLoader[K comparable, V any](bulkLoader BulkLoader[K, V]) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.bulkLoader = bulkLoader.withContext()
+	}
+}
+
+// WithBulkLoaderContext provides a context-aware bulk loader option when creating a new memo.
+func WithBulkLoaderContext[K comparable, V any](bulkLoader BulkLoaderContext[K, V]) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.bulkLoader = bulkLoader
 	}
 }
 
@@ -62,24 +254,195 @@ func WithExpiration[K comparable, V any](expiration time.Duration) Option[K, V]
 	}
 }
 
+// WithErrorExpiration provides an expiration option for errors returned
+// by the loader when creating a new memo, by default errors are cached
+// with the same expiration as values.
+func WithErrorExpiration[K comparable, V any](errorExpiration time.Duration) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.errorExpiration, o.errorExpirationSet = errorExpiration, true
+	}
+}
+
+// WithErrorPredicate provides a predicate option for errors returned by
+// the loader when creating a new memo, by default all errors are cached.
+func WithErrorPredicate[K comparable, V any](errorPredicate ErrorPredicate) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.errorPredicate = errorPredicate
+	}
+}
+
+// WithSlidingExpiration provides a sliding expiration option when creating
+// a new memo, if it is enabled, each hit of memo.Get pushes the expiration
+// time of the value forward by the expiration it is set or loaded with, so
+// values expire after they are not accessed for a while.
+func WithSlidingExpiration[K comparable, V any](slidingExpiration bool) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.slidingExpiration = slidingExpiration
+	}
+}
+
+// WithMaxEntries provides a max entries option when creating a new memo,
+// once the limit is reached, an entry chosen by the policy will be evicted.
+// Values being loaded are counted only once they are cached.
+func WithMaxEntries[K comparable, V any](maxEntries int) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.maxEntries = maxEntries
+	}
+}
+
+// WithMaxWeight provides a max weight option when creating a new memo, once
+// the total weight of entries exceeds it, entries chosen by the policy will be
+// evicted until it fits. The weight is measured by the weigher, which is 1 for
+// each entry by default. An entry heavier than the limit of its shard alone is
+// evicted on its own. It can be combined with max entries.
+func WithMaxWeight[K comparable, V any](maxWeight int64) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.maxWeight = maxWeight
+	}
+}
+
+// WithWeigher provides a weigher option when creating a new memo.
+func WithWeigher[K comparable, V any](weigher Weigher[K, V]) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.weigher = weigher
+	}
+}
+
+// WithPolicy provides an eviction policy option when creating a new memo, it
+// takes effect only if max entries or max weight is provided, the default is LRU.
+func WithPolicy[K comparable, V any](policy Policy) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.policy = policy
+	}
+}
+
+// WithExpirationIndex provides an expiration index option when creating
+// a new memo, the default is Heap.
+func WithExpirationIndex[K comparable, V any](index ExpirationIndex) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.expirationIndex = index
+	}
+}
+
+// WithShards provides a shards option when creating a new memo, keys are
+// partitioned by hash into shards, which are locked independently, the
+// default is 1. Note that max entries and max weight are divided equally
+// among shards, the remainder going to the first shards, so they must be at
+// least the number of shards.
+func WithShards[K comparable, V any](shards int) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.shards = shards
+	}
+}
+
+// WithRefreshInterval provides a refresh interval option when creating a new
+// memo, once a value is older than the interval but has not expired yet, the
+// memo.Get returns it immediately and reloads it in background. The interval
+// should be less than the expiration, otherwise it makes no sense.
+func WithRefreshInterval[K comparable, V any](refreshInterval time.Duration) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.refreshInterval = refreshInterval
+	}
+}
+
+// WithJanitor provides a janitor option when creating a new memo, the janitor
+// runs in background to remove expired entries, it wakes up when the earliest
+// entry expires, but at least once per interval. Call memo.Close to stop it.
+func WithJanitor[K comparable, V any](interval time.Duration) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.janitorInterval = interval
+	}
+}
+
+// WithOnRemove provides a removal listener option when creating a new memo,
+// the listener is notified when a value leaves the memo for expiration,
+// deletion, replacement or eviction. Errors and values being loaded are
+// not notified.
+func WithOnRemove[K comparable, V any](onRemove RemovalListener[K, V]) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.onRemove = onRemove
+	}
+}
+
+// WithStore provides a second tier store option when creating a new memo,
+// see Store.
+func WithStore[K comparable, V any](store Store[K, V]) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.store = store
+	}
+}
+
+// WithLoadRetry provides a retry policy option when creating a new memo,
+// which acts on the loader and the bulk loader, see RetryPolicy.
+func WithLoadRetry[K comparable, V any](policy RetryPolicy) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.retryPolicy = &policy
+	}
+}
+
+// WithOnStoreError provides a handler option when creating a new memo, it
+// is invoked with the key when the store fails, except for ErrNotFound.
+func WithOnStoreError[K comparable, V any](onStoreError func(K, error)) Option[K, V] {
+	return func(o *options[K, V]) {
+		o.onStoreError = onStoreError
+	}
+}
+
 // options holds all extra configs needed when getting a value from the memo.
 type getOptions[K comparable, V any] struct {
-	// Load a value by key when is not found.
-	loader Loader[K, V]
+	// Load a value by key when is not found, at most
+	// one of them is set.
+	loader    LoaderContext[K, V]
+	ttlLoader LoaderWithTTLContext[K, V]
+	// Load values by keys when are not found.
+	bulkLoader BulkLoaderContext[K, V]
 	// Expiration for the value to be loaded.
 	expiration time.Duration
+	// Expiration for the error to be loaded.
+	errorExpiration    time.Duration
+	errorExpirationSet bool
+	// Whether to push the expiration time forward on a hit.
+	slidingExpiration bool
+	// Predicate for the error to be loaded.
+	errorPredicate ErrorPredicate
+}
+
+// loads reports whether a loader is provided.
+func (o *getOptions[K, V]) loads() bool {
+	return o.loader != nil || o.ttlLoader != nil
+}
+
+// load invokes the loader, and returns the value with its TTL, which
+// is the expiration option unless the loader decides it.
+func (o *getOptions[K, V]) load(ctx context.Context, k K) (V, time.Duration, error) {
+	if o.ttlLoader != nil {
+		return o.ttlLoader(ctx, k)
+	}
+
+	v, err := o.loader(ctx, k)
+
+	return v, o.expiration, err
 }
 
 // GetOption specifies the option when getting a value from the memo.
 type GetOption[K comparable, V any] func(*getOptions[K, V])
 
 func (base *options[K, V]) newGetOptions(opts ...GetOption[K, V]) getOptions[K, V] {
-	o := getOptions[K, V]{loader: base.loader, expiration: base.expiration}
+	o := getOptions[K, V]{
+		loader:             base.loader,
+		ttlLoader:          base.ttlLoader,
+		bulkLoader:         base.bulkLoader,
+		expiration:         base.expiration,
+		errorExpiration:    base.errorExpiration,
+		errorExpirationSet: base.errorExpirationSet,
+		errorPredicate:     base.errorPredicate,
+		slidingExpiration:  base.slidingExpiration,
+	}
 	for _, opt := range opts {
 		opt(&o)
 	}
 
-	if o.expiration < 0 {
+	if o.expiration < 0 || o.errorExpiration < 0 {
 		panic(ErrInvalidExpiration)
 	}
 
@@ -89,7 +452,44 @@ func (base *options[K, V]) newGetOptions(opts ...GetOption[K, V]) getOptions[K,
 // GetWithLoader provides a loader option when getting a value from the memo.
 func GetWithLoader[K comparable, V any](loader Loader[K, V]) GetOption[K, V] {
 	return func(o *getOptions[K, V]) {
-		o.loader = loader
+		o.loader, o.ttlLoader = loader.withContext(), nil
+	}
+}
+
+// GetWithLoaderContext provides a context-aware loader option when getting a value from the memo.
+func GetWithLoaderContext[K comparable, V any](loader LoaderContext[K, V]) GetOption[K, V] {
+	return func(o *getOptions[K, V]) {
+		o.loader, o.ttlLoader = loader, nil
+	}
+}
+
+// GetWithLoaderWithTTL provides a loader option when getting a value from
+// the memo, the loader decides the expiration of the loaded value.
+func GetWithLoaderWithTTL[K comparable, V any](loader LoaderWithTTL[K, V]) GetOption[K, V] {
+	return func(o *getOptions[K, V]) {
+		o.loader, o.ttlLoader = nil, loader.withContext()
+	}
+}
+
+// GetWithLoaderWithTTLContext provides a context-aware loader option when getting
+// a value from the memo, the loader decides the expiration of the loaded value.
+func GetWithLoaderWithTTLContext[K comparable, V any](loader LoaderWithTTLContext[K, V]) GetOption[K, V] {
+	return func(o *getOptions[K, V]) {
+		o.loader, o.ttlLoader = nil, loader
+	}
+}
+
+// GetWithBulkLoader provides a bulk loader option when getting values from the memo.
+func GetWithBulkLoader[K comparable, V any](bulkLoader BulkLoader[K, V]) GetOption[K, V] {
+	return func(o *getOptions[K, V]) {
+		o.bulkLoader = bulkLoader.withContext()
+	}
+}
+
+// GetWithBulkLoaderContext provides a context-aware bulk loader option when getting values from the memo.
+func GetWithBulkLoaderContext[K comparable, V any](bulkLoader BulkLoaderContext[K, V]) GetOption[K, V] {
+	return func(o *getOptions[K, V]) {
+		o.bulkLoader = bulkLoader
 	}
 }
 
@@ -100,10 +500,36 @@ func GetWithExpiration[K comparable, V any](expiration time.Duration) GetOption[
 	}
 }
 
+// GetWithErrorExpiration provides an expiration option for the error
+// to be loaded when getting a value from the memo.
+func GetWithErrorExpiration[K comparable, V any](errorExpiration time.Duration) GetOption[K, V] {
+	return func(o *getOptions[K, V]) {
+		o.errorExpiration, o.errorExpirationSet = errorExpiration, true
+	}
+}
+
+// GetWithErrorPredicate provides a predicate option for the error
+// to be loaded when getting a value from the memo.
+func GetWithErrorPredicate[K comparable, V any](errorPredicate ErrorPredicate) GetOption[K, V] {
+	return func(o *getOptions[K, V]) {
+		o.errorPredicate = errorPredicate
+	}
+}
+
+// GetWithSlidingExpiration provides a sliding expiration option when getting
+// a value from the memo, which decides whether the hit pushes the expiration.
+func GetWithSlidingExpiration[K comparable, V any](slidingExpiration bool) GetOption[K, V] {
+	return func(o *getOptions[K, V]) {
+		o.slidingExpiration = slidingExpiration
+	}
+}
+
 // options holds all extra configs needed when setting a value to the memo.
 type setOptions[K comparable, V any] struct {
 	// Expiration for the value to be set.
 	expiration time.Duration
+	// Tags for the value to be set.
+	tags []string
 }
 
 // SetOption specifies the option when setting a value to the memo.
@@ -128,3 +554,12 @@ func SetWithExpiration[K comparable, V any](expiration time.Duration) SetOption[
 		o.expiration = expiration
 	}
 }
+
+// SetWithTags provides tags when setting a value to the memo, so that
+// it can be removed by memo.InvalidateTag. The tags replace those of
+// the previous value of the key.
+func SetWithTags[K comparable, V any](tags ...string) SetOption[K, V] {
+	return func(o *setOptions[K, V]) {
+		o.tags = append(o.tags, tags...)
+	}
+}
@@ -0,0 +1,175 @@
+package memo
+
+const (
+	// The wheel has levels of buckets, the tick of level 0 is 2^20ns,
+	// about 1ms, and each level has 64 buckets, whose span is the tick
+	// of the next level, so the span of the top level is about 13 days.
+	wheelLevels  = 5
+	wheelBits    = 6
+	wheelBuckets = 1 << wheelBits
+	wheelShift   = 20
+	// The slot of entries found expired, after all buckets.
+	wheelExpired = wheelLevels * wheelBuckets
+)
+
+// timingWheel is a hierarchical timing wheel of entries, an entry is
+// put in the bucket of the lowest level which covers its expireAt, and
+// moved to lower levels when the tick of its bucket begins, until it is
+// found expired when the tick of its bucket in level 0 ends. The slot of
+// each entry is kept in its position, and the entries in the same slot
+// are linked by tprev and tnext.
+type timingWheel[K comparable, V any] struct {
+	// The time the wheel is advanced to.
+	nanos int64
+	// The first entry of each slot.
+	heads [wheelExpired + 1]*entry[K, V]
+}
+
+func newTimingWheel[K comparable, V any]() *timingWheel[K, V] {
+	return &timingWheel[K, V]{}
+}
+
+func (w *timingWheel[K, V]) schedule(e *entry[K, V]) {
+	w.remove(e)
+
+	if e.expireAt != zeroExpireAt {
+		w.place(e)
+	}
+}
+
+func (w *timingWheel[K, V]) remove(e *entry[K, V]) {
+	if e.position != zeroPosition {
+		w.unlink(e)
+	}
+}
+
+func (w *timingWheel[K, V]) expired(now int64) *entry[K, V] {
+	if w.heads[wheelExpired] == nil {
+		w.advance(now)
+	}
+
+	e := w.heads[wheelExpired]
+	if e != nil {
+		w.unlink(e)
+	}
+
+	return e
+}
+
+func (w *timingWheel[K, V]) next() int64 {
+	if w.heads[wheelExpired] != nil {
+		return w.nanos
+	}
+
+	earliest := int64(zeroExpireAt)
+
+	for i := range wheelLevels {
+		shift := wheelShift + i*wheelBits
+		ticks := w.nanos >> shift
+
+		// Level 0 is checked from the current tick, which ends next,
+		// other levels are checked from the next tick, which begins
+		// next.
+		for k := min(i, 1); k <= wheelBuckets; k++ {
+			if w.heads[i*wheelBuckets+int((ticks+int64(k))&(wheelBuckets-1))] == nil {
+				continue
+			}
+
+			at := (ticks + int64(k)) << shift
+			if i == 0 {
+				at += 1 << shift
+			}
+
+			if earliest == zeroExpireAt || at < earliest {
+				earliest = at
+			}
+
+			break
+		}
+	}
+
+	return earliest
+}
+
+// advance moves the wheel to now, the entries in the buckets whose
+// tick begins are placed again, and so are those in the buckets of
+// level 0 whose tick ends, which are expired mostly.
+func (w *timingWheel[K, V]) advance(now int64) {
+	if now <= w.nanos {
+		return
+	}
+
+	prev := w.nanos
+	w.nanos = now
+
+	for i := range wheelLevels {
+		shift := wheelShift + i*wheelBits
+		prevTicks, ticks := prev>>shift, now>>shift
+
+		if ticks == prevTicks {
+			break
+		}
+
+		start := prevTicks
+		if i != 0 {
+			start++
+		}
+
+		for t := start; t < start+min(ticks-prevTicks, wheelBuckets); t++ {
+			slot := i*wheelBuckets + int(t&(wheelBuckets-1))
+
+			e := w.heads[slot]
+			w.heads[slot] = nil
+
+			for e != nil {
+				next := e.tnext
+				e.position, e.tprev, e.tnext = zeroPosition, nil, nil
+				w.place(e)
+				e = next
+			}
+		}
+	}
+}
+
+// place links the entry into the slot for its expireAt.
+func (w *timingWheel[K, V]) place(e *entry[K, V]) {
+	w.link(e, w.slot(e.expireAt))
+}
+
+// slot returns the slot for an entry expiring at expireAt.
+func (w *timingWheel[K, V]) slot(expireAt int64) int {
+	d := expireAt - w.nanos
+	if d <= 0 {
+		return wheelExpired
+	}
+
+	i := 0
+	for i < wheelLevels-1 && d >= 1<<(wheelShift+(i+1)*wheelBits) {
+		i++
+	}
+
+	return i*wheelBuckets + int((expireAt>>(wheelShift+i*wheelBits))&(wheelBuckets-1))
+}
+
+func (w *timingWheel[K, V]) link(e *entry[K, V], slot int) {
+	e.position, e.tprev, e.tnext = slot, nil, w.heads[slot]
+	if e.tnext != nil {
+		e.tnext.tprev = e
+	}
+
+	w.heads[slot] = e
+}
+
+func (w *timingWheel[K, V]) unlink(e *entry[K, V]) {
+	if e.tprev != nil {
+		e.tprev.tnext = e.tnext
+	} else {
+		w.heads[e.position] = e.tnext
+	}
+
+	if e.tnext != nil {
+		e.tnext.tprev = e.tprev
+	}
+
+	e.position, e.tprev, e.tnext = zeroPosition, nil, nil
+}